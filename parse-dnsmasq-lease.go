@@ -1,122 +1,5797 @@
 package main
 
 import (
-	"bufio"          // For reading the file line by line
-	"fmt"            // For formatted output
-	"log"            // For logging errors
-	"os"             // For file operations, environment variables, and standard output
-	"strconv"        // For converting string to number (timestamp)
-	"strings"        // For splitting strings
-	"text/tabwriter" // For formatting output as a table
-	"time"           // For time operations
+	"bufio"              // For reading the file line by line
+	"bytes"              // For comparing IP addresses and buffering screen output
+	"compress/bzip2"     // For reading rotated .bz2 lease files
+	"compress/gzip"      // For reading rotated .gz lease files
+	"context"            // For canceling the lease file tail
+	"crypto/rand"        // For the per-run anonymization salt
+	"crypto/sha256"      // For lease hashes
+	"encoding/binary"    // For protobuf and snappy encoding of remote-write requests
+	"encoding/csv"       // For --vendor-file OUI registries and --format csv
+	"encoding/hex"       // For encoding lease hashes
+	"encoding/json"      // For JSON output and webhook payloads
+	"errors"             // For reporting every unreadable lease file
+	"flag"               // For command-line flags
+	"fmt"                // For formatted output
+	"io"                 // For the generic output writer
+	"log/slog"           // For leveled, structured diagnostics
+	"log/syslog"         // For --syslog
+	"math"               // For encoding float samples
+	mathrand "math/rand" // For --random-sample
+	"net"                // For detecting the IP address family
+	"net/http"           // For delivering webhooks
+	"net/netip"          // For address pool arithmetic
+	"os"                 // For file operations, environment variables, and standard output
+	"os/exec"            // For switching the terminal into raw mode via stty
+	"os/signal"          // For stopping --follow on Ctrl+C
+	"path"               // For matching hostname patterns
+	"path/filepath"      // For finding lease files in a directory
+	"regexp"             // For matching dnsmasq log lines
+	"runtime"            // For garbage collecting before a heap profile
+	"runtime/pprof"      // For --profile-cpu and --profile-mem
+	"slices"             // For copying and searching slices
+	"sort"               // For sorting leases in the interactive view
+	"strconv"            // For converting string to number (timestamp)
+	"strings"            // For splitting strings
+	"sync"               // For parsing lease files concurrently and serializing syslog records
+	"syscall"            // For redrawing --tui on SIGWINCH
+	"text/tabwriter"     // For formatting output as a table
+	"time"               // For time operations
+	"unicode"            // For zero-width marks in table cells
+	"unicode/utf8"       // For counting the characters of --separator-line
+
+	"golang.org/x/text/collate"                     // For --hostname-sort-locale
+	"golang.org/x/text/encoding/charmap"            // For --output-encoding latin1
+	unicodeenc "golang.org/x/text/encoding/unicode" // For --output-encoding utf16le/utf16be
+	"golang.org/x/text/language"                    // For parsing the --hostname-sort-locale tag
+	"golang.org/x/text/runes"                       // For replacing characters latin1 lacks
+	"golang.org/x/text/transform"                   // For transcoding the output
+	"golang.org/x/text/width"                       // For the display width of table cells
 )
 
 // LeaseEntry represents a single DHCP lease record
 type LeaseEntry struct {
-	ExpiryTime time.Time // Lease expiration time
-	MACAddress string    // Client MAC address
-	IPAddress  string    // Assigned IP address
-	Hostname   string    // Client hostname (can be '*')
-	ClientID   string    // Client identifier (can be '*')
+	ExpiryTime time.Time `json:"expiry_time"`         // Lease expiration time
+	MACAddress string    `json:"mac_address"`         // Client MAC address
+	IPAddress  string    `json:"ip_address"`          // Assigned IP address
+	Hostname   string    `json:"hostname"`            // Client hostname (can be '*')
+	ClientID   string    `json:"client_id"`           // Client identifier (can be '*')
+	Permanent  bool      `json:"permanent"`           // True for infinite leases (dnsmasq writes a timestamp of 0)
+	Tags       string    `json:"tags,omitempty"`      // Optional comma-separated tags column (6-field lease files only)
+	Source     string    `json:"source,omitempty"`    // Lease file the entry was read from
+	StartTime  time.Time `json:"start_time,omitzero"` // Last DHCPACK seen in the dnsmasq log (--log)
+}
+
+// Hash returns a stable SHA-256 hex digest of the lease's normalized fields.
+// The MAC is lowercased and the expiry is reduced to Unix seconds (0 for permanent
+// leases), so equal leases hash equally regardless of formatting or source file.
+func (l LeaseEntry) Hash() string {
+	expiry := int64(0)
+	if !l.Permanent {
+		expiry = l.ExpiryTime.Unix()
+	}
+	normalized := strings.Join([]string{
+		strconv.FormatInt(expiry, 10),
+		strings.ToLower(l.MACAddress),
+		l.IPAddress,
+		l.Hostname,
+		l.ClientID,
+		l.Tags,
+	}, "\x00")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// GrantedAt estimates when the lease was granted, given the configured lease duration.
+// It returns the zero time for permanent leases, which carry no timing information.
+func (l LeaseEntry) GrantedAt(leaseDuration time.Duration) time.Time {
+	if l.Permanent {
+		return time.Time{}
+	}
+	return l.ExpiryTime.Add(-leaseDuration)
+}
+
+// Active reports whether the lease is still valid at the given moment
+func (l LeaseEntry) Active(now time.Time) bool {
+	return l.Permanent || l.ExpiryTime.After(now)
 }
 
+// clock returns the moment leases are evaluated against (active, remaining, ...) and every
+// time shown to the user. --simulate-now sets it to a fixed start that advances in whole
+// seconds, so a single run sees exactly that time while watch and wait still move on.
+var clock = time.Now
+
 const defaultLeaseFilePath = "/var/lib/misc/dnsmasq.leases" // Default path to the dnsmasq.leases file
 const envVarLeasePath = "DNSMASQ_LEASES"                    // Environment variable name for the lease file path
 
-func main() {
-	// Determine the lease file path
-	leaseFilePath := os.Getenv(envVarLeasePath)
-	if leaseFilePath == "" {
-		leaseFilePath = defaultLeaseFilePath
-		log.Printf("Info: Environment variable %s not set, using default path: %s", envVarLeasePath, defaultLeaseFilePath)
-	} else {
-		log.Printf("Info: Using lease file path from environment variable %s: %s", envVarLeasePath, leaseFilePath)
+// outputFormats lists the values accepted by --format
+var outputFormats = []string{"table", "json", "hosts", "dhcp-host", "resolv-conf", "iptables", "nftables", "prometheus", "ansible", "kv", "jinja2-vars", "csv", "csv-no-header", "dns-zone", "influx", "python", "ruby", "toml", "graphviz", "bind-rpz", "nmap", "syslog", "terraform-output"}
+
+// flagChoices lists the fixed values of enumerated flags, used for shell completion
+var flagChoices = map[string][]string{
+	"format":          outputFormats,
+	"tags-column":     {"no", "auto", "yes"},
+	"timestamp-unit":  {"s", "ms"},
+	"group-by":        groupKeys,
+	"mac-case":        macCases,
+	"syslog-facility": syslogFacilities,
+	"terraform-key":   {"mac", "hostname"},
+	"completion":      {"bash", "zsh", "fish"},
+	"log-level":       {"debug", "info", "warn", "error"},
+	"log-format":      {"text", "json"},
+	"sort":            sortKeys,
+	"output-encoding": outputEncodings,
+	"columns":         columnNames,
+	"fields":          columnNames,
+}
+
+// fileFlags are the flags whose value is a path, completed as file names
+var fileFlags = map[string]bool{"file": true, "dir": true, "log": true, "output": true, "profile-cpu": true, "profile-mem": true, "state-file": true, "vendor-file": true}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// options holds the values of all command-line flags
+type options struct {
+	Columns stringList // Columns to show with --columns/--fields, comma-separated or repeated
+	columns []string   // Parsed Columns, in display order
+
+	Files  stringList // Lease files given with --file
+	Dir    string     // Directory whose *.leases files are read
+	Source bool       // Show the Source column
+
+	DecodeClientID bool   // Add a column interpreting the client identifier
+	Remaining      bool   // Show the time left instead of the expiry time
+	LeaseDuration  int    // Configured dnsmasq lease time in seconds, for deriving grant times
+	AgeColumn      bool   // Show how long each lease has been held
+	Log            string // dnsmasq log file for lease start times
+	Hash           bool   // Add the lease hash column / JSON field
+	ShowVendor     bool   // Add the vendor column / JSON field
+	VendorFile     string // IEEE registry CSV replacing the built-in vendor table
+	DetectRandom   bool   // Add the randomized-MAC column / JSON field
+	CIDRColumn     bool   // Add the subnet column / JSON field
+	CIDRPrefixLen  int    // IPv4 prefix length of the subnet column (IPv6 uses /64)
+
+	Sort           string            // Comma-separated sort keys, see sortKeys (empty keeps file order)
+	Reverse        bool              // Reverse the sort order
+	HostnameLocale string            // Locale for --sort hostname
+	collator       *collate.Collator // Built from HostnameLocale
+
+	MACAnonymize bool   // Replace MAC addresses with salted hashes
+	Anonymize    bool   // Replace MAC addresses, hostnames and client IDs with salted hashes
+	Salt         string // Fixed anonymization salt (random per run when empty)
+	BucketIPs    bool   // With --anonymize, reduce addresses to their /24 (IPv6: /64)
+	salt         []byte // Salt in effect for this run
+	ShowBoth     bool   // Show both the expiry time and the time left
+
+	Pools            stringList // Address pools (CIDR) used by --report-gaps
+	ReportGaps       bool       // Print the pool addresses without an active lease
+	Histogram        bool       // Print a bar chart of leases by time until expiry
+	HistogramBounds  string     // Comma-separated bucket boundaries for --histogram
+	histogramBounds  []time.Duration
+	ExpiryHistogram  bool          // Print a bar chart of leases by expiry time window
+	HistogramBucket  time.Duration // Window width of --expiry-histogram
+	CheckConsistency bool          // Report leases outside every pool and exit 1 if there are any
+	Reconcile        string        // Reservations API URL to reconcile the active leases against
+	ARP              bool          // Cross-check the active leases with the kernel ARP table
+
+	Format         string // Output format (table, iptables, nftables)
+	Chain          string // Firewall chain name for the iptables/nftables formats
+	Domain         string // Domain suffix appended to hostnames in --format hosts
+	Zone           string // Zone name (origin) for --format dns-zone and bind-rpz
+	RouterIP       string // Address shown on the central router node of --format graphviz
+	Ports          string // Comma-separated ports turning --format nmap targets into IP:PORT pairs
+	TerraformKey   string // Key of the --format terraform-output object: mac or hostname
+	SyslogAddr     string // Syslog server for --format syslog: [udp://|tcp://]HOST:PORT (empty: local daemon)
+	SyslogFacility string // Facility of the --format syslog messages
+	ports          []int
+	GroupBy        string // Split the table into one sub-table per vendor or subnet
+
+	SeparatorLine   string // Character underlining the table header
+	NoSeparatorLine bool   // Omit the line under the table header
+	NoHeader        bool   // Omit the header row of the table and CSV output
+
+	JSONTimeFormat string // Time serialization in --format json: rfc3339, unix, unix-milli, rfc850, custom:LAYOUT
+
+	Output         string // File to write the listing or report to instead of standard output
+	OutputEncoding string // Character encoding of the output: utf8, latin1, utf16le, utf16be
+	NoFlushPerRow  bool   // Buffer the output instead of writing each row as it is produced
+
+	CSVDelimiter string // Field separator for the CSV formats
+	CSVQuoteAll  bool   // Quote every CSV field, not only those that need it
+	csvDelimiter rune   // Parsed CSVDelimiter
+
+	DNSServerMACs string // Comma-separated MACs of DNS servers for --format resolv-conf
+	TUI           bool   // Start the interactive lease browser instead of printing
+
+	TagsColumn     string        // Whether lines carry a 6th tags field: auto, yes, no
+	TimestampUnit  string        // Unit of the expiry timestamps: s or ms (empty: detect)
+	UnknownTokens  string        // Comma-separated hostname/client ID values meaning "unknown", normalized to *
+	MACCase        string        // Letter case of displayed MAC addresses: lower or upper
+	MaxAge         time.Duration // Warn and exit 1 when a lease file was last modified longer ago than this
+	CheckFuture    bool          // Warn about leases expiring implausibly far in the future
+	StateFile      string        // File recording the MACs seen by the previous run
+	NewSinceLast   bool          // Keep only leases whose MAC is not in --state-file, then update it
+	FutureMax      time.Duration // How far ahead an expiry may be before --check-future flags it
+	RetryOnPartial bool          // Read a file again when it looks truncated by a concurrent rewrite
+	Parallel       int           // Number of lease files parsed concurrently
+	ReadTimeout    time.Duration // Give up on a lease file that cannot be read within this time (0: wait forever)
+	Tag            string        // Keep only leases carrying one of these comma-separated tags
+
+	Active      bool          // Keep only leases that have not expired
+	Hostname    string        // Keep only hostnames matching this glob
+	Subnet      string        // Keep only addresses inside this CIDR
+	subnet      netip.Prefix  // Parsed Subnet
+	IPRange     string        // Keep only addresses within "FROM,TO" (inclusive)
+	IPv4Only    bool          // Keep only IPv4 addresses
+	IPv6Only    bool          // Keep only IPv6 addresses
+	MinExpiry   time.Duration // Drop leases expiring sooner than this from now (including expired ones)
+	MaxExpiry   time.Duration // Drop leases expiring later than this from now (including permanent ones)
+	ExpireIn    time.Duration // Keep only leases that have not expired yet but will within this duration
+	HideRandom  bool          // Drop leases of randomized (locally administered) MACs
+	Vendor      string        // Keep only leases whose vendor contains this (case-insensitive)
+	HideUnknown bool          // Drop leases without a hostname
+	OnlyRandom  bool          // Keep only leases of randomized MACs
+	DedupeIP    bool          // Keep only the latest-expiring lease per IP address
+	Sample      int           // Keep only this many randomly selected leases
+	PerMACMax   int           // Keep only MACs holding more than this many leases
+	Seed        int64         // Seed of the --random-sample selection (time-based unless given)
+	rangeLo     netip.Addr    // Parsed IPRange start
+	rangeHi     netip.Addr    // Parsed IPRange end
+
+	Watch         bool          // Re-read and re-print the leases periodically
+	WatchInterval time.Duration // Delay between polls in watch mode
+	WatchDiff     bool          // In watch mode, print only added/removed leases
+	ChangesOnly   bool          // In watch mode, print a timestamped line per added/removed/changed lease
+	Follow        bool          // Stream newly appearing leases as they are written
+
+	Webhook        string        // URL that receives lease changes as JSON in watch mode
+	PostURL        string        // URL that receives the leases as a JSON array instead of printing them
+	ChangeWebhook  string        // URL that receives only added/removed leases as JSON in watch mode
+	WebhookTimeout time.Duration // Timeout of a single webhook request
+	WebhookRetries int           // Retries after a failed webhook request
+
+	Completion string // Shell to print a completion script for
+	ListFields bool   // Print the available fields and exit
+
+	MACToIP      string // Print the IP addresses leased to this MAC
+	IPToMAC      string // Print the MAC addresses holding this IP
+	IPToHostname string // Print the hostname of the lease holding this IP
+	HostnameToIP string // Print the addresses leased under this hostname
+	WithHostname bool   // Add the hostname to --ip-to-mac results
+	Resolve      bool   // Add reverse DNS names to listings and the lookup detail view
+	Progress     bool   // Show a progress bar on stderr while reading many files or resolving names
+
+	reverseNames map[string]string // Reverse DNS names by IP address, looked up by render for --resolve
+
+	RemoteWrite     string // Prometheus remote-write endpoint to push metrics to
+	RemoteWriteAuth string // Authorization header value for the remote-write request
+
+	MetricPrefix string        // Prefix of every metric name, e.g. dnsmasq_
+	MetricLabels stringList    // Constant key=value labels added to every metric
+	metricLabels []metricLabel // Parsed MetricLabels
+
+	SimulateNow string // Pretend the current time is this, for checking fixtures
+
+	LogLevel  string // Minimum level of diagnostics: debug, info, warn, error
+	Quiet     bool   // Log only errors, overriding LogLevel
+	Verbose   bool   // Log every skipped line instead of a summary
+	LogFormat string // Diagnostics format: text or json
+	Syslog    bool   // Send diagnostics to the local syslog daemon instead of stderr
+
+	ProfileCPU string // Write a pprof CPU profile to this file
+	ProfileMem string // Write a pprof heap profile to this file on exit
+
+	Command string   // Sub-command, see subcommands (empty for the default table/format output)
+	Args    []string // Positional arguments following the sub-command
+}
+
+// subcommands are the accepted sub-commands and their usage lines
+var subcommands = map[string]string{
+	"list":             "list                 Print the leases as a table (the default without a command)",
+	"stats":            "stats                Print summary statistics of the leases",
+	"watch":            "watch                Re-read the lease files periodically and re-print them (same as --watch)",
+	"export":           "export               Print the leases in --format (default json) for other tools",
+	"validate":         "validate             Check that the lease files parse; list malformed lines and exit 1 if any",
+	"show":             "show MAC             Print every field of the lease(s) held by MAC as labeled lines",
+	"lookup":           "lookup IP|MAC        Print every field of the lease(s) holding an IP or MAC (--resolve adds reverse DNS)",
+	"wait":             "wait MAC DURATION    Poll until MAC has an active lease and print its IP; exit 1 on timeout",
+	"update-vendor-db": "update-vendor-db     Download the IEEE MAC registries into --vendor-file",
+	"count":            "count active|expired|total|expiring-soon DURATION\n                       Print the number of matching leases",
+}
+
+// isFlagSet reports whether the named flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// setupLogging installs the default slog logger writing diagnostics to w
+// at the given level, as logfmt-style text or as JSON lines. With toSyslog
+// they go to the local syslog daemon instead.
+func setupLogging(w io.Writer, level, format string, toSyslog bool) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid --log-level %q, expected debug, info, warn or error", level)
+	}
+	handlerOpts := &slog.HandlerOptions{Level: lvl}
+	var sysw *syslog.Writer
+	if toSyslog {
+		var err error
+		if sysw, err = syslog.New(syslog.LOG_INFO|syslog.LOG_USER, programName); err != nil {
+			return fmt.Errorf("connecting to syslog: %w", err)
+		}
+		w = new(bytes.Buffer)
+		handlerOpts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+				return slog.Attr{} // Syslog records both itself
+			}
+			return a
+		}
+	}
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(w, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	default:
+		return fmt.Errorf("invalid --log-format %q, expected text or json", format)
+	}
+	if sysw != nil {
+		handler = &syslogHandler{Handler: handler, buf: w.(*bytes.Buffer), mu: new(sync.Mutex), w: sysw}
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// syslogHandler sends each record formatted by the embedded handler (which writes to buf)
+// to syslog, at the priority matching its level
+type syslogHandler struct {
+	slog.Handler
+	buf *bytes.Buffer
+	mu  *sync.Mutex // Guards buf, shared with the handlers derived by WithAttrs and WithGroup
+	w   *syslog.Writer
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf.Reset()
+	if err := h.Handler.Handle(ctx, r); err != nil {
+		return err
 	}
+	msg := strings.TrimSuffix(h.buf.String(), "\n")
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.w.Err(msg)
+	case r.Level >= slog.LevelWarn:
+		return h.w.Warning(msg)
+	case r.Level >= slog.LevelInfo:
+		return h.w.Info(msg)
+	default:
+		return h.w.Debug(msg)
+	}
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{Handler: h.Handler.WithAttrs(attrs), buf: h.buf, mu: h.mu, w: h.w}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{Handler: h.Handler.WithGroup(name), buf: h.buf, mu: h.mu, w: h.w}
+}
+
+// fatalf logs an error event and exits with status 1
+func fatalf(format string, args ...any) {
+	slog.Error(fmt.Sprintf(format, args...))
+	exit(1)
+}
+
+// stopProfiling flushes the profiles started by startProfiling; it is replaced once they are running
+var stopProfiling = func() {}
+
+// exit flushes any profiles and exits with the given status, since os.Exit skips deferred calls
+func exit(code int) {
+	stopProfiling()
+	os.Exit(code)
+}
+
+// startProfiling starts a CPU profile written to cpuFile and arranges for stopProfiling
+// to write it and a heap profile to memFile; either path may be empty
+func startProfiling(cpuFile, memFile string) error {
+	var cpu *os.File
+	if cpuFile != "" {
+		f, err := os.Create(cpuFile)
+		if err != nil {
+			return fmt.Errorf("creating CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("starting CPU profile: %w", err)
+		}
+		cpu = f
+	}
+	stopProfiling = func() {
+		stopProfiling = func() {} // Only once, whether reached by exit or a deferred call
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			if err := cpu.Close(); err != nil {
+				slog.Warn("writing CPU profile failed", "file", cpuFile, "error", err)
+			}
+		}
+		if memFile != "" {
+			if err := writeHeapProfile(memFile); err != nil {
+				slog.Warn("writing memory profile failed", "file", memFile, "error", err)
+			}
+		}
+	}
+	return nil
+}
 
-	// Open the lease file
-	file, err := os.Open(leaseFilePath)
+// writeHeapProfile writes a heap profile reflecting the allocations up to now to path
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
 	if err != nil {
-		// If the file is not found or permissions are denied, log the error and exit
-		log.Fatalf("Error opening file %s: %v", leaseFilePath, err)
+		return err
 	}
-	// Ensure the file is closed when the main function exits
-	defer file.Close()
+	runtime.GC() // Get up-to-date statistics
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
 
-	var leases []LeaseEntry // Slice to store the parsed lease entries
+// joinIPRangeArgs rewrites "--ip-range FROM TO" into "--ip-range=FROM,TO",
+// since the flag package only supports a single value per flag
+func joinIPRangeArgs(args []string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		if (args[i] == "--ip-range" || args[i] == "-ip-range") && i+2 < len(args) {
+			if _, err := netip.ParseAddr(args[i+2]); err == nil {
+				out = append(out, args[i]+"="+args[i+1]+","+args[i+2])
+				i += 2
+				continue
+			}
+		}
+		if args[i] == "--" {
+			return append(out, args[i:]...) // Everything after -- is positional
+		}
+		out = append(out, args[i])
+	}
+	return out
+}
 
-	scanner := bufio.NewScanner(file) // Create a scanner to read the file line by line
-	lineNumber := 0
+// parseIPRange parses "FROM,TO" or "FROM-TO" into an ordered pair of same-family addresses
+func parseIPRange(value string) (netip.Addr, netip.Addr, error) {
+	sep := ","
+	if !strings.Contains(value, ",") {
+		sep = "-"
+	}
+	from, to, ok := strings.Cut(value, sep)
+	if !ok {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("expected FROM TO")
+	}
+	lo, err := netip.ParseAddr(strings.TrimSpace(from))
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+	hi, err := netip.ParseAddr(strings.TrimSpace(to))
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+	lo, hi = lo.Unmap(), hi.Unmap()
+	if lo.BitLen() != hi.BitLen() {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("addresses are of different families")
+	}
+	if lo.Compare(hi) > 0 {
+		lo, hi = hi, lo
+	}
+	return lo, hi, nil
+}
 
-	// Read the file line by line
-	for scanner.Scan() {
-		lineNumber++
-		line := scanner.Text()
-		fields := strings.Fields(line) // Split the line by whitespace
+// parseFlags reads the command-line flags into an options value
+func parseFlags() options {
+	var opts options
+	flag.Var(&opts.Columns, "columns", "Comma-separated columns to show, in this order (repeatable; see --list-fields); independent of --sort")
+	flag.Var(&opts.Columns, "fields", "Alias for --columns")
+	flag.Var(&opts.Files, "file", "Lease file to read (repeatable; default $"+envVarLeasePath+" or "+defaultLeaseFilePath+")")
+	flag.StringVar(&opts.Dir, "dir", "", "Read and merge every *.leases file in this directory")
+	flag.BoolVar(&opts.Source, "source", false, "Add a Source column showing which file each lease came from")
+	flag.BoolVar(&opts.Remaining, "remaining", false, "Show the time left on each lease instead of the expiry time")
+	flag.IntVar(&opts.LeaseDuration, "lease-duration", 0, "dnsmasq lease time in seconds (dhcp-range lease time), used to derive when leases were granted")
+	flag.BoolVar(&opts.AgeColumn, "age-column", false, "Add an Age column (time since the lease was granted); requires --lease-duration")
+	flag.StringVar(&opts.Log, "log", "", "dnsmasq log file; DHCPACK lines add Start and Lease Time columns (FILE.1 is read too)")
+	flag.BoolVar(&opts.ShowBoth, "show-both", false, "Show both the expiry time and a Remaining column")
+	flag.Var(&opts.Pools, "pool", "DHCP address pool in CIDR notation (repeatable)")
+	flag.BoolVar(&opts.ARP, "arp", false, "Compare the active leases with the ARP table ("+arpTablePath+") and report disagreeing MAC/IP pairs and ARP entries without a lease")
+	flag.StringVar(&opts.Reconcile, "reconcile", "", "GET a JSON list of expected reservations from this URL and report matched, unexpected and missing devices")
+	flag.BoolVar(&opts.Histogram, "histogram", false, "Print a bar chart of the leases bucketed by time until expiry")
+	flag.BoolVar(&opts.ExpiryHistogram, "expiry-histogram", false, "Print a bar chart of how many leases expire in each --histogram-bucket window, instead of listing them")
+	flag.DurationVar(&opts.HistogramBucket, "histogram-bucket", time.Hour, "Window width of --expiry-histogram, e.g. 15m or 24h")
+	flag.StringVar(&opts.HistogramBounds, "histogram-bounds", "1h,6h,24h", "Comma-separated, increasing bucket boundaries for --histogram")
+	flag.BoolVar(&opts.CheckConsistency, "check-consistency", false, "Report leases whose IP is outside every --pool; exit 1 if there are any")
+	flag.BoolVar(&opts.ReportGaps, "report-gaps", false, "Print the addresses of each --pool that have no active lease")
+	flag.BoolVar(&opts.Hash, "hash", false, "Add a SHA-256 hash of each lease (table column / JSON field) for change detection")
+	flag.StringVar(&opts.Sort, "sort", "", "Sort by a comma-separated list of: "+strings.Join(sortKeys, ", ")+" (default file order)")
+	flag.BoolVar(&opts.Reverse, "reverse", false, "Reverse the --sort order")
+	flag.StringVar(&opts.HostnameLocale, "hostname-sort-locale", "", "Locale (e.g. de, sv-SE) for locale-aware --sort hostname")
+	flag.BoolVar(&opts.MACAnonymize, "mac-anonymize", false, "Replace MAC addresses with per-run salted hashes (stable within one run)")
+	flag.BoolVar(&opts.Anonymize, "anonymize", false, "Replace MAC addresses, hostnames and client IDs with salted hashes for analytics exports")
+	flag.StringVar(&opts.Salt, "salt", "", "Salt for --anonymize/--mac-anonymize, to keep hashes joinable across runs (default random per run)")
+	flag.BoolVar(&opts.BucketIPs, "bucket-ips", false, "With --anonymize, replace addresses by their /24 (IPv6: /64) network")
+	flag.BoolVar(&opts.CIDRColumn, "cidr-column", false, "Add a Subnet column: the network of each IP with --cidr-prefix-length (IPv6: /64)")
+	flag.IntVar(&opts.CIDRPrefixLen, "cidr-prefix-length", 24, "IPv4 prefix length of the --cidr-column subnet, 0-32")
+	flag.BoolVar(&opts.ShowVendor, "show-vendor", false, "Add a Vendor column (manufacturer from the MAC address prefix)")
+	flag.StringVar(&opts.VendorFile, "vendor-file", "", "IEEE registry CSV (Registry,Assignment,Organization Name,...) to look vendors up in instead of the built-in table")
+	flag.BoolVar(&opts.DecodeClientID, "decode-client-id", false, "Add a column interpreting the client identifier (RFC 2132 9.14 / RFC 4361)")
+	flag.StringVar(&opts.Format, "format", "table", "Output format: "+strings.Join(outputFormats, ", "))
+	flag.StringVar(&opts.TerraformKey, "terraform-key", "mac", "Key of the --format terraform-output object: mac or hostname")
+	flag.StringVar(&opts.SyslogAddr, "syslog-addr", "", "Syslog server for --format syslog, [udp://|tcp://]HOST:PORT (default: the local syslog daemon)")
+	flag.StringVar(&opts.SyslogFacility, "syslog-facility", "daemon", "Facility of the --format syslog messages: "+strings.Join(syslogFacilities, ", "))
+	flag.StringVar(&opts.Ports, "ports", "", "Comma-separated ports for --format nmap, printing one IP:PORT target per port, e.g. 22,443")
+	flag.StringVar(&opts.Chain, "chain", "", "Target chain for --format iptables/nftables (default FORWARD / forward)")
+	flag.StringVar(&opts.SeparatorLine, "separator-line", "-", "Character underlining each table header, e.g. '=' or '─'")
+	flag.BoolVar(&opts.NoSeparatorLine, "no-separator-line", false, "Omit the line under the table header")
+	flag.BoolVar(&opts.NoHeader, "no-header", false, "Omit the header row (and its separator line) of the table and CSV output")
+	flag.StringVar(&opts.JSONTimeFormat, "json-time-format", "rfc3339", "Times in --format json: rfc3339, unix, unix-milli, rfc850 or custom:GO-LAYOUT")
+	flag.StringVar(&opts.Output, "output", "", "Write the listing or report to this file instead of standard output")
+	flag.BoolVar(&opts.NoFlushPerRow, "no-flush-per-row", false, "Buffer the output and write it in large blocks instead of row by row (faster for very large lease files)")
+	flag.StringVar(&opts.OutputEncoding, "output-encoding", "utf8", "Character encoding of the output: "+strings.Join(outputEncodings, ", "))
+	flag.StringVar(&opts.CSVDelimiter, "csv-delimiter", ",", "Field separator for --format csv, e.g. ';' (use 'tab' for a tab)")
+	flag.StringVar(&opts.CSVDelimiter, "csv-delim", ",", "Alias for --csv-delimiter")
+	flag.BoolVar(&opts.CSVQuoteAll, "csv-quote-all", false, "Quote every field in --format csv, not only those containing the delimiter, quotes or newlines")
+	flag.StringVar(&opts.GroupBy, "group-by", "", "Print one table per group with a count heading: "+strings.Join(groupKeys, ", "))
+	flag.StringVar(&opts.RouterIP, "router-ip", "", "Address to label the central router node with in --format graphviz")
+	flag.StringVar(&opts.Zone, "zone-name", "", "Zone for --format dns-zone (e.g. home.lan) and bind-rpz (e.g. rpz.home.lan)")
+	flag.StringVar(&opts.Domain, "domain", "", "Domain suffix for --format hosts and bind-rpz, e.g. lan")
+	flag.StringVar(&opts.DNSServerMACs, "dns-server-mac", "", "Comma-separated MACs of DNS servers for --format resolv-conf")
+	flag.BoolVar(&opts.TUI, "tui", false, "Browse the leases interactively (scroll, sort, filter, reload)")
+	flag.StringVar(&opts.StateFile, "state-file", "", "File recording the MAC addresses seen by the previous run, for --new-since-last")
+	flag.BoolVar(&opts.NewSinceLast, "new-since-last", false, "List only leases whose MAC was not seen by the previous run, then record the current MACs in --state-file")
+	flag.BoolVar(&opts.CheckFuture, "check-future", false, "Warn about leases expiring more than --future-threshold from now (clock skew or corrupted timestamps)")
+	flag.DurationVar(&opts.FutureMax, "future-threshold", 30*24*time.Hour, "How far ahead an expiry may be before --check-future warns about it")
+	flag.DurationVar(&opts.MaxAge, "max-age", 0, "Warn and exit 1 if a lease file was last modified longer ago than this, e.g. 24h (liveness check)")
+	flag.BoolVar(&opts.RetryOnPartial, "retry-on-partial", false, "Read a lease file once more after a short delay when it looks truncated mid-write")
+	flag.DurationVar(&opts.ReadTimeout, "read-timeout", 0, "Fail if a lease file cannot be opened and read within this time, e.g. 10s for NFS mounts that may hang (default: wait forever)")
+	flag.StringVar(&opts.MACCase, "mac-case", "lower", "Letter case of MAC addresses in every output format: lower or upper")
+	flag.StringVar(&opts.UnknownTokens, "unknown-tokens", "*", "Comma-separated hostname and client ID values meaning unknown, e.g. '*,-' for forks writing -")
+	flag.IntVar(&opts.Parallel, "parallel", 4, "Number of lease files parsed concurrently when reading several")
+	flag.StringVar(&opts.TimestampUnit, "timestamp-unit", "", "Unit of the expiry timestamps: s (dnsmasq) or ms (some embedded builds); detected when unset")
+	flag.StringVar(&opts.TagsColumn, "tags-column", "no", "Trailing tags field: no (strict 5 fields), auto (detect when every line has 6 fields), yes")
+	flag.BoolVar(&opts.Active, "active", false, "Keep only leases that have not expired")
+	flag.StringVar(&opts.Hostname, "hostname", "", "Keep only hostnames matching this glob (case-insensitive), e.g. 'pi-*'")
+	flag.StringVar(&opts.Subnet, "subnet", "", "Keep only addresses inside this CIDR, e.g. 192.168.1.0/24")
+	flag.StringVar(&opts.IPRange, "ip-range", "", "Keep only addresses in the inclusive range FROM TO (also FROM,TO or FROM-TO), like dnsmasq's dhcp-range")
+	flag.BoolVar(&opts.IPv4Only, "ipv4-only", false, "Keep only leases with an IPv4 address")
+	flag.BoolVar(&opts.IPv6Only, "ipv6-only", false, "Keep only leases with an IPv6 address")
+	flag.DurationVar(&opts.MinExpiry, "min-expiry", 0, "Keep only leases expiring at least this far from now, e.g. 30m (drops expired leases)")
+	flag.DurationVar(&opts.MaxExpiry, "max-expiry", 0, "Keep only leases expiring less than this far from now, e.g. 6h (drops permanent leases)")
+	flag.DurationVar(&opts.ExpireIn, "expire-in", 0, "Keep only leases that are still active but expire within this duration, e.g. 2h")
+	flag.DurationVar(&opts.ExpireIn, "expiring-within", 0, "Alias for --expire-in")
+	flag.IntVar(&opts.PerMACMax, "leases-per-mac-max", 0, "Keep only MACs holding more than N leases, e.g. 1 for MACs with several IPs")
+	flag.IntVar(&opts.Sample, "random-sample", 0, "Show only N leases selected at random from the filtered leases, for spot checks")
+	flag.Int64Var(&opts.Seed, "seed", 0, "Seed for --random-sample, to get the same selection again (default time-based)")
+	flag.BoolVar(&opts.DedupeIP, "dedupe-ip", false, "Keep only the latest-expiring lease per IP address (ties go to the lowest MAC)")
+	flag.BoolVar(&opts.DedupeIP, "unique-ips", false, "Alias for --dedupe-ip")
+	flag.BoolVar(&opts.DetectRandom, "detect-random", false, "Add a Random column flagging privacy-randomized (locally administered) MACs")
+	flag.BoolVar(&opts.HideUnknown, "hide-unknown", false, "Drop leases whose hostname is unknown (see --unknown-tokens)")
+	flag.StringVar(&opts.Vendor, "vendor", "", "Keep only leases whose MAC vendor contains this text (case-insensitive), e.g. 'raspberry'")
+	flag.BoolVar(&opts.HideRandom, "hide-random", false, "Drop leases whose MAC is randomized (locally administered)")
+	flag.BoolVar(&opts.OnlyRandom, "only-random", false, "Keep only leases whose MAC is randomized (locally administered)")
+	flag.StringVar(&opts.Tag, "tag", "", "Keep only leases with one of these comma-separated tags")
+	flag.BoolVar(&opts.Watch, "watch", false, "Re-read the lease file periodically and re-print it")
+	flag.DurationVar(&opts.WatchInterval, "interval", 2*time.Second, "Poll interval for --watch")
+	flag.BoolVar(&opts.WatchDiff, "watch-diff", false, "With --watch, print only leases added (+) or removed (-) since the last poll")
+	flag.BoolVar(&opts.ChangesOnly, "changes-only", false, "With --watch, print no table but one timestamped line per added, removed or changed lease")
+	flag.BoolVar(&opts.Follow, "follow", false, "Stream each newly appearing lease as a '+' line until interrupted")
+	flag.StringVar(&opts.Webhook, "webhook", "", "With --watch, POST added/removed/changed leases as JSON to this URL")
+	flag.StringVar(&opts.PostURL, "post-url", "", "POST the leases as a JSON array to this URL once instead of printing them")
+	flag.StringVar(&opts.ChangeWebhook, "change-webhook", "", "Watch and POST {\"added\": [...], \"removed\": [...]} to this URL whenever the lease set changes")
+	flag.DurationVar(&opts.WebhookTimeout, "webhook-timeout", 10*time.Second, "Timeout of a single webhook request")
+	flag.IntVar(&opts.WebhookRetries, "webhook-retries", 3, "Retries with exponential backoff after a failed webhook request")
+	flag.StringVar(&opts.RemoteWrite, "remote-write", "", "Push lease metrics to this Prometheus remote-write URL instead of printing")
+	flag.StringVar(&opts.RemoteWriteAuth, "remote-write-auth", "", "Authorization header for --remote-write, e.g. 'Bearer TOKEN'")
+	flag.StringVar(&opts.MetricPrefix, "metric-prefix", "dnsmasq_", "Prefix of the metric names in --format prometheus and --remote-write")
+	flag.Var(&opts.MetricLabels, "metric-label", "Constant label key=value added to every metric, e.g. instance=router1 (repeatable)")
+	flag.StringVar(&opts.MACToIP, "mac-to-ip", "", "Print the IP address(es) leased to this MAC, one per line; exit 1 if none")
+	flag.StringVar(&opts.IPToMAC, "ip-to-mac", "", "Print the MAC address(es) holding this IP, one per line; exit 1 if none")
+	flag.StringVar(&opts.IPToHostname, "ip-to-hostname", "", "Print the hostname (* if unknown) for this IP; exit 1 if the IP has no lease")
+	flag.StringVar(&opts.HostnameToIP, "hostname-to-ip", "", "Print every IP leased under this hostname; exit 1 if none")
+	flag.BoolVar(&opts.Progress, "progress", false, "Show a progress bar on stderr while reading several lease files or resolving names (only on a terminal)")
+	flag.BoolVar(&opts.Resolve, "resolve", false, "Add the reverse DNS names of each address (a Reverse DNS column; a line with the lookup command)")
+	flag.BoolVar(&opts.WithHostname, "with-hostname", false, "Print the hostname next to each --ip-to-mac result")
+	flag.StringVar(&opts.LogLevel, "log-level", "info", "Minimum level of diagnostics on stderr: debug, info, warn, error")
+	flag.BoolVar(&opts.Quiet, "quiet", false, "Log only errors (same as --log-level error)")
+	flag.BoolVar(&opts.Verbose, "verbose", false, "Log each skipped malformed line instead of a single summary per file")
+	flag.StringVar(&opts.LogFormat, "log-format", "text", "Format of diagnostics on stderr: text or json")
+	flag.BoolVar(&opts.Syslog, "syslog", false, "Send diagnostics to the local syslog daemon (as "+programName+") instead of stderr")
+	flag.StringVar(&opts.ProfileCPU, "profile-cpu", "", "Write a pprof CPU profile of the run to this file")
+	flag.StringVar(&opts.ProfileMem, "profile-mem", "", "Write a pprof heap profile to this file when exiting")
+	flag.StringVar(&opts.SimulateNow, "simulate-now", "", "Evaluate leases as if the current time were this (RFC 3339, 'YYYY-MM-DD HH:MM:SS' local time, or Unix seconds)")
+	flag.BoolVar(&opts.ListFields, "list-fields", false, "Print the parsed and computed field names, whether the current flags enable them, and exit")
+	flag.StringVar(&opts.Completion, "completion", "", "Print a shell completion script (bash, zsh, fish) and exit")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [flags]\n       %s [flags] COMMAND [ARGS]\n\nCommands:\n", programName, programName)
+		names := make([]string, 0, len(subcommands))
+		for name := range subcommands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(out, "  %s\n", subcommands[name])
+		}
+		fmt.Fprintf(out, "\nFlags:\n")
+		flag.PrintDefaults()
+	}
+	// The flag package stops at the first positional argument, so keep parsing
+	// after each one; this allows flags both before and after the sub-command.
+	// Once -- has been consumed everything left is positional, even if it
+	// looks like a flag
+	args := joinIPRangeArgs(os.Args[1:])
+	var positional []string
+	for {
+		flag.CommandLine.Parse(args)
+		if consumed := len(args) - flag.NArg(); consumed > 0 && args[consumed-1] == "--" {
+			positional = append(positional, flag.Args()...)
+			break
+		}
+		if flag.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flag.Arg(0))
+		args = flag.Args()[1:]
+	}
+	if opts.Quiet {
+		opts.LogLevel = "error"
+	}
+	for _, path := range []*string{&opts.Log, &opts.Output, &opts.ProfileCPU, &opts.ProfileMem, &opts.StateFile, &opts.VendorFile} {
+		expanded, err := ExpandPath(*path)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		*path = expanded
+	}
+	if err := setupLogging(os.Stderr, opts.LogLevel, opts.LogFormat, opts.Syslog); err != nil {
+		fatalf("%v", err)
+	}
+	if len(positional) > 0 {
+		opts.Command, opts.Args = positional[0], positional[1:]
+		if _, ok := subcommands[opts.Command]; !ok {
+			fatalf("unknown command %q", opts.Command)
+		}
+	}
+	switch opts.Command {
+	case "list", "stats", "watch", "export", "validate", "update-vendor-db":
+		if len(opts.Args) != 0 {
+			fatalf("usage: %s [flags] %s", programName, opts.Command)
+		}
+	}
+	if opts.Command == "watch" {
+		opts.Watch = true
+	}
+	if opts.Command == "update-vendor-db" && opts.VendorFile == "" {
+		fatalf("update-vendor-db requires --vendor-file PATH to write the registries to")
+	}
+	if opts.Command == "export" && !isFlagSet("format") {
+		opts.Format = "json" // A table is for people, export is for tools
+	}
+	if opts.Command == "lookup" && len(opts.Args) != 1 {
+		fatalf("usage: %s lookup IP|MAC", programName)
+	}
+	if opts.Command == "show" && len(opts.Args) != 1 {
+		fatalf("usage: %s show MAC", programName)
+	}
+	if opts.Command == "count" {
+		usage := "usage: " + programName + " count active|expired|total|expiring-soon DURATION"
+		if len(opts.Args) == 0 {
+			fatalf("%s", usage)
+		}
+		switch opts.Args[0] {
+		case "active", "expired", "total":
+			if len(opts.Args) != 1 {
+				fatalf("%s", usage)
+			}
+		case "expiring-soon":
+			if len(opts.Args) != 2 {
+				fatalf("%s", usage)
+			}
+			if _, err := time.ParseDuration(opts.Args[1]); err != nil {
+				fatalf("invalid expiring-soon duration %q: %v", opts.Args[1], err)
+			}
+		default:
+			fatalf("unknown count %q, %s", opts.Args[0], usage)
+		}
+	}
+	if opts.Command == "wait" {
+		if len(opts.Args) != 2 {
+			fatalf("usage: %s wait MAC DURATION", programName)
+		}
+		if _, err := net.ParseMAC(opts.Args[0]); err != nil {
+			fatalf("invalid MAC address %q: %v", opts.Args[0], err)
+		}
+		if _, err := time.ParseDuration(opts.Args[1]); err != nil {
+			fatalf("invalid wait duration %q: %v", opts.Args[1], err)
+		}
+	}
+	if opts.MetricPrefix != "" && !metricNamePattern.MatchString(opts.MetricPrefix) {
+		fatalf("invalid --metric-prefix %q, expected letters, digits, _ and : not starting with a digit", opts.MetricPrefix)
+	}
+	for _, pair := range opts.MetricLabels {
+		label, err := parseMetricLabel(pair)
+		if err != nil {
+			fatalf("invalid --metric-label: %v", err)
+		}
+		opts.metricLabels = append(opts.metricLabels, label)
+	}
+	if opts.PerMACMax < 0 {
+		fatalf("invalid --leases-per-mac-max %d, expected a positive count", opts.PerMACMax)
+	}
+	if opts.Sample < 0 {
+		fatalf("invalid --random-sample %d, expected a positive count", opts.Sample)
+	}
+	if opts.Sample > 0 && !isFlagSet("seed") {
+		opts.Seed = time.Now().UnixNano()
+		slog.Debug("random sample seed", "seed", opts.Seed) // Pass it to --seed to repeat the selection
+	}
+	if opts.GroupBy != "" && indexOf(groupKeys, opts.GroupBy) < 0 {
+		fatalf("invalid --group-by %q, expected one of %s", opts.GroupBy, strings.Join(groupKeys, ", "))
+	}
+	if opts.Parallel < 1 {
+		fatalf("invalid --parallel %d, expected at least 1", opts.Parallel)
+	}
+	if indexOf(macCases, opts.MACCase) < 0 {
+		fatalf("invalid --mac-case %q, expected lower or upper", opts.MACCase)
+	}
+	if opts.TimestampUnit != "" && opts.TimestampUnit != "s" && opts.TimestampUnit != "ms" {
+		fatalf("invalid --timestamp-unit %q, expected s or ms", opts.TimestampUnit)
+	}
+	if opts.TagsColumn != "auto" && opts.TagsColumn != "yes" && opts.TagsColumn != "no" {
+		fatalf("invalid --tags-column %q, expected auto, yes or no", opts.TagsColumn)
+	}
+	if opts.WatchDiff || opts.ChangesOnly || opts.ChangeWebhook != "" {
+		opts.Watch = true // --watch-diff, --changes-only and --change-webhook only make sense in watch mode
+	}
+	if opts.Webhook != "" && !opts.Watch {
+		fatalf("--webhook only applies with --watch; use --post-url URL to POST the leases once")
+	}
+	if opts.PostURL != "" && opts.Watch {
+		fatalf("--post-url POSTs the leases once; use --webhook URL to POST changes in watch mode")
+	}
+	if opts.AgeColumn && opts.LeaseDuration <= 0 {
+		fatalf("--age-column requires --lease-duration SECONDS")
+	}
+	if opts.NewSinceLast && opts.StateFile == "" {
+		fatalf("--new-since-last requires --state-file PATH")
+	}
+	if _, err := path.Match(opts.Hostname, ""); err != nil {
+		fatalf("invalid --hostname pattern %q: %v", opts.Hostname, err)
+	}
+	if opts.Subnet != "" {
+		subnet, err := netip.ParsePrefix(opts.Subnet)
+		if err != nil {
+			fatalf("invalid --subnet %q: %v", opts.Subnet, err)
+		}
+		opts.subnet = subnet.Masked()
+	}
+	if opts.IPv4Only && opts.IPv6Only {
+		fatalf("--ipv4-only and --ipv6-only are mutually exclusive")
+	}
+	for _, value := range opts.Columns {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if indexOf(columnNames, name) < 0 {
+				fatalf("invalid column %q, expected one of %s", name, strings.Join(columnNames, ", "))
+			}
+			if name == "age" && opts.LeaseDuration <= 0 {
+				fatalf("the age column requires --lease-duration SECONDS")
+			}
+			opts.columns = append(opts.columns, name)
+		}
+	}
+	if len(opts.columns) > 0 && !slices.Contains([]string{"table", "json", "csv", "csv-no-header"}, opts.Format) {
+		name := "columns"
+		if isFlagSet("fields") {
+			name = "fields"
+		}
+		fatalf("--%s applies to --format table, json, csv and csv-no-header, not %s", name, opts.Format)
+	}
+	if opts.ExpiryHistogram && opts.HistogramBucket <= 0 {
+		fatalf("invalid --histogram-bucket %v, expected a positive duration", opts.HistogramBucket)
+	}
+	if opts.TerraformKey != "mac" && opts.TerraformKey != "hostname" {
+		fatalf("invalid --terraform-key %q, expected mac or hostname", opts.TerraformKey)
+	}
+	if indexOf(syslogFacilities, opts.SyslogFacility) < 0 {
+		fatalf("invalid --syslog-facility %q, expected one of %s", opts.SyslogFacility, strings.Join(syslogFacilities, ", "))
+	}
+	if opts.CIDRPrefixLen < 0 || opts.CIDRPrefixLen > 32 {
+		fatalf("invalid --cidr-prefix-length %d, expected 0 to 32", opts.CIDRPrefixLen)
+	}
+	if opts.Ports != "" {
+		for _, value := range strings.Split(opts.Ports, ",") {
+			port, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || port < 1 || port > 65535 {
+				fatalf("invalid --ports %q: expected comma-separated ports from 1 to 65535", opts.Ports)
+			}
+			opts.ports = append(opts.ports, port)
+		}
+	}
+	if opts.Histogram {
+		var previous time.Duration
+		for _, value := range strings.Split(opts.HistogramBounds, ",") {
+			bound, err := time.ParseDuration(strings.TrimSpace(value))
+			if err != nil || bound <= previous {
+				fatalf("invalid --histogram-bounds %q: expected increasing positive durations such as 1h,6h,24h", opts.HistogramBounds)
+			}
+			opts.histogramBounds = append(opts.histogramBounds, bound)
+			previous = bound
+		}
+	}
+	switch {
+	case indexOf([]string{"rfc3339", "unix", "unix-milli", "rfc850"}, opts.JSONTimeFormat) >= 0:
+	case strings.HasPrefix(opts.JSONTimeFormat, "custom:") && len(opts.JSONTimeFormat) > len("custom:"):
+	default:
+		fatalf("invalid --json-time-format %q, expected rfc3339, unix, unix-milli, rfc850 or custom:GO-LAYOUT", opts.JSONTimeFormat)
+	}
+	if utf8.RuneCountInString(opts.SeparatorLine) != 1 {
+		fatalf("invalid --separator-line %q, expected a single character", opts.SeparatorLine)
+	}
+	if indexOf(outputEncodings, opts.OutputEncoding) < 0 {
+		fatalf("invalid --output-encoding %q, expected one of %s", opts.OutputEncoding, strings.Join(outputEncodings, ", "))
+	}
+	if opts.CSVDelimiter == "tab" || opts.CSVDelimiter == `\t` {
+		opts.CSVDelimiter = "\t" // Spelled out, as a literal tab is awkward to type in a shell
+	}
+	// The csv writer itself rejects the delimiters it cannot use (quote, newline, ...)
+	probe := csv.NewWriter(io.Discard)
+	if delimiter := []rune(opts.CSVDelimiter); len(delimiter) == 1 {
+		probe.Comma = delimiter[0]
+	}
+	if utf8.RuneCountInString(opts.CSVDelimiter) != 1 || probe.Write(nil) != nil {
+		fatalf("invalid --csv-delimiter %q, expected a single character other than a quote or newline", opts.CSVDelimiter)
+	}
+	opts.csvDelimiter = probe.Comma
+	if opts.SimulateNow != "" {
+		simulated, err := parseSimulatedTime(opts.SimulateNow)
+		if err != nil {
+			fatalf("invalid --simulate-now %q: %v", opts.SimulateNow, err)
+		}
+		start := time.Now()
+		clock = func() time.Time { return simulated.Add(time.Since(start).Truncate(time.Second)) }
+	}
+	if opts.MinExpiry > 0 && opts.MaxExpiry > 0 && opts.MinExpiry >= opts.MaxExpiry {
+		fatalf("--min-expiry %v is not less than --max-expiry %v", opts.MinExpiry, opts.MaxExpiry)
+	}
+	if opts.ExpireIn < 0 {
+		name := "expire-in"
+		if isFlagSet("expiring-within") {
+			name = "expiring-within"
+		}
+		fatalf("invalid --%s %v, expected a positive duration", name, opts.ExpireIn)
+	}
+	if opts.HideRandom && opts.OnlyRandom {
+		fatalf("--hide-random and --only-random are mutually exclusive")
+	}
+	if opts.IPRange != "" {
+		lo, hi, err := parseIPRange(opts.IPRange)
+		if err != nil {
+			fatalf("invalid --ip-range %q: %v", opts.IPRange, err)
+		}
+		opts.rangeLo, opts.rangeHi = lo, hi
+	}
+	if opts.Sort != "" {
+		for _, key := range strings.Split(opts.Sort, ",") {
+			if indexOf(sortKeys, key) < 0 {
+				fatalf("invalid --sort key %q, expected one of %s", key, strings.Join(sortKeys, ", "))
+			}
+		}
+	}
+	if opts.HostnameLocale != "" {
+		// POSIX names such as en_US.UTF-8 are accepted too
+		locale, _, _ := strings.Cut(opts.HostnameLocale, ".")
+		tag, err := language.Parse(strings.ReplaceAll(locale, "_", "-"))
+		if err != nil {
+			fatalf("invalid --hostname-sort-locale %q: %v", opts.HostnameLocale, err)
+		}
+		opts.collator = collate.New(tag)
+	}
+	if opts.Salt != "" {
+		opts.salt = []byte(opts.Salt)
+	} else if opts.MACAnonymize || opts.Anonymize {
+		opts.salt = make([]byte, 16)
+		if _, err := rand.Read(opts.salt); err != nil {
+			fatalf("generating anonymization salt: %v", err)
+		}
+	}
+	return opts
+}
 
-		// Each valid line should contain 5 fields
-		if len(fields) != 5 {
-			log.Printf("Warning: Skipping line %d: Invalid number of fields (%d), expected 5. Line: '%s'", lineNumber, len(fields), line)
-			continue // Skip malformed line
+// partialRetryDelay is how long --retry-on-partial waits before reading a file again
+const partialRetryDelay = 200 * time.Millisecond
+
+// parseSimulatedTime parses a --simulate-now value: RFC 3339, "YYYY-MM-DD HH:MM:SS"
+// in local time, or Unix seconds as written in lease files
+func parseSimulatedTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+}
+
+// parseLeaseFile reads and parses a dnsmasq lease file.
+// Malformed lines are skipped and summarized in one warning (one per line with --verbose). With --retry-on-partial a file that
+// looks like it was caught mid-write is read once more after a short delay.
+func parseLeaseFile(leaseFilePath string, opts options) ([]LeaseEntry, error) {
+	leases, skipped, partial, err := readLeaseFile(leaseFilePath, opts)
+	if err != nil {
+		return nil, err
+	}
+	if partial && opts.RetryOnPartial {
+		slog.Info("lease file looks partially written, reading it again", "file", leaseFilePath, "delay", partialRetryDelay)
+		time.Sleep(partialRetryDelay)
+		if leases, skipped, _, err = readLeaseFile(leaseFilePath, opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Verbose {
+		for _, skipped := range skipped {
+			slog.Warn("skipping malformed line", "file", leaseFilePath, "line", skipped.Line, "reason", skipped.Reason)
 		}
+	} else if len(skipped) > 0 {
+		// One summary keeps the output readable for badly broken files
+		slog.Warn(fmt.Sprintf("skipped %d malformed lines; run with --verbose to see each", len(skipped)), "file", leaseFilePath, "count", len(skipped))
+	}
+	slog.Debug("parsed lease file", "file", leaseFilePath, "leases", len(leases), "skipped", len(skipped))
+	return leases, nil
+}
+
+// unsupportedArchives are compressed formats the standard library cannot decompress
+var unsupportedArchives = []string{".xz", ".zst"}
 
-		// Parse the Unix timestamp (first field)
-		expiryTimestampUnix, err := strconv.ParseInt(fields[0], 10, 64)
+// openLeaseFile opens a lease file, transparently decompressing .gz and .bz2 archives.
+// .xz and .zst archives are rejected; other files are read as plain text.
+func openLeaseFile(path string) (io.ReadCloser, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if slices.Contains(unsupportedArchives, ext) {
+		return nil, fmt.Errorf("unsupported compression %s, decompress the file first (only .gz and .bz2 are read directly)", ext)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	switch ext {
+	case ".gz":
+		zr, err := gzip.NewReader(file)
 		if err != nil {
-			log.Printf("Warning: Skipping line %d: Error parsing timestamp '%s': %v", lineNumber, fields[0], err)
-			continue // Skip line with invalid timestamp format
+			file.Close()
+			return nil, err
 		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{zr, file}, nil
+	case ".bz2":
+		return struct {
+			io.Reader
+			io.Closer
+		}{bzip2.NewReader(file), file}, nil
+	default:
+		return file, nil
+	}
+}
+
+// skippedLine records a malformed lease file line
+type skippedLine struct {
+	Line   int   // 1-based line number
+	Reason error // Why the line could not be parsed
+}
+
+// readLeaseData reads a whole lease file, decompressing rotated archives
+func readLeaseData(leaseFilePath string) ([]byte, error) {
+	// Open the lease file, decompressing rotated archives
+	file, err := openLeaseFile(leaseFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file %s: %w", leaseFilePath, err)
+	}
+	// Ensure the file is closed when the function returns
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file %s: %w", leaseFilePath, err)
+	}
+	return data, nil
+}
+
+// withReadTimeout runs a file system call, giving up after timeout (0 waits forever), for
+// files on network mounts where glob, stat, open or read can hang indefinitely (--read-timeout).
+// A blocked system call cannot be interrupted, so the goroutine running it is abandoned;
+// it exits on its own should the call ever return. what names the call in the error.
+func withReadTimeout[T any](timeout time.Duration, what string, call func() (T, error)) (T, error) {
+	if timeout <= 0 {
+		return call()
+	}
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1) // Buffered so an abandoned call never blocks
+	go func() {
+		value, err := call()
+		done <- result{value, err}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, fmt.Errorf("%s: no result after %v (hung network mount?): %w", what, timeout, ctx.Err())
+	}
+}
+
+// statTimeout is os.Stat under --read-timeout
+func statTimeout(path string, timeout time.Duration) (os.FileInfo, error) {
+	return withReadTimeout(timeout, "stat "+path, func() (os.FileInfo, error) { return os.Stat(path) })
+}
+
+// leaseParseOptions returns the ParseOptions selected by the command-line flags
+func leaseParseOptions(opts options) ParseOptions {
+	return ParseOptions{
+		TagsColumn:    opts.TagsColumn,
+		TimestampUnit: opts.TimestampUnit,
+		UnknownTokens: opts.UnknownTokens,
+		MACCase:       opts.MACCase,
+	}
+}
 
-		// Convert Unix timestamp (seconds) to time.Time
-		expiryTime := time.Unix(expiryTimestampUnix, 0)
+// readLeaseFile parses a lease file with a LeaseScanner, returning the skipped lines instead
+// of logging them. partial reports a truncated read: the last line has no newline or is
+// malformed (dnsmasq terminates every line, so either means the read raced with a writer).
+func readLeaseFile(leaseFilePath string, opts options) (leases []LeaseEntry, skipped []skippedLine, partial bool, err error) {
+	// Read the whole file first so the column layout can be detected before parsing
+	data, err := withReadTimeout(opts.ReadTimeout, "reading file "+leaseFilePath, func() ([]byte, error) {
+		return readLeaseData(leaseFilePath)
+	})
+	if err != nil {
+		return nil, nil, false, err
+	}
+	partial = len(data) > 0 && data[len(data)-1] != '\n'
 
-		// Create a LeaseEntry record
-		lease := LeaseEntry{
-			ExpiryTime:  expiryTime,
-			MACAddress:  fields[1],
-			IPAddress:   fields[2],
-			Hostname:    fields[3],
-			ClientID:    fields[4],
+	parseOpts := leaseParseOptions(opts)
+	if parseOpts.TagsColumn == "auto" || parseOpts.TimestampUnit == "" {
+		lines := strings.Split(string(data), "\n")
+		// The tags column is only assumed when every line has one
+		if parseOpts.TagsColumn == "auto" {
+			parseOpts.TagsColumn = "no"
+			if hasTagsColumn(lines) {
+				parseOpts.TagsColumn = "yes"
+			}
+		}
+		// Some embedded builds write milliseconds; unless told, recognize them by their 13 digits
+		if parseOpts.TimestampUnit == "" {
+			parseOpts.TimestampUnit = "s"
+			if hasMilliTimestamps(lines) {
+				parseOpts.TimestampUnit = "ms"
+				slog.Warn("expiry timestamps look like milliseconds; pass --timestamp-unit ms (or s) to silence this", "file", leaseFilePath)
+			}
 		}
-		leases = append(leases, lease) // Add the parsed record to the slice
 	}
 
-	// Check for errors encountered during scanning
+	// Parse the file line by line, with room for a lease per line
+	leases = make([]LeaseEntry, 0, bytes.Count(data, []byte{'\n'})+1)
+	scanner := NewLeaseScanner(bytes.NewReader(data))
+	scanner.Options = parseOpts
+	lastSkipped := 0
+	for scanner.Scan() {
+		if warning := scanner.Warning(); warning != nil {
+			skipped = append(skipped, skippedLine{Line: warning.Line, Reason: warning.Err})
+			lastSkipped = warning.Line
+			continue // Skip malformed line
+		}
+		leases = append(leases, scanner.Lease()) // Add the parsed record to the slice
+	}
 	if err := scanner.Err(); err != nil {
-		log.Fatalf("Error reading file %s: %v", leaseFilePath, err)
+		return nil, nil, false, fmt.Errorf("error reading file %s: %w", leaseFilePath, err)
 	}
-
-	// If no leases were found, print a message and exit
-	if len(leases) == 0 {
-		fmt.Println("No lease entries found or file is empty.")
-		return
+	if lastSkipped > 0 && lastSkipped == scanner.line {
+		partial = true // A malformed last line is typically a record cut off mid-write
 	}
+	return leases, skipped, partial, nil
+}
 
-	// --- Print the table ---
-	// Use tabwriter for nicely formatted columns
-	// Parameters: output io.Writer, minwidth, tabwidth, padding, padchar, flags
-	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+// parseLeaseLine parses one lease file line with the given number of fields
+// (5, or 6 when the file has a tags column)
+func parseLeaseLine(line string, expectedFields int, unit string) (LeaseEntry, error) {
+	fields := strings.Fields(line) // Split the line by whitespace
+
+	// Each valid line should contain 5 fields (6 with a tags column)
+	if len(fields) != expectedFields {
+		return LeaseEntry{}, fmt.Errorf("Invalid number of fields (%d), expected %d. Line: '%s'", len(fields), expectedFields, line)
+	}
 
-	// Print table header
-	// Use \t as a column separator for tabwriter
-	fmt.Fprintln(writer, "Expiry Time\tMAC Address\tIP Address\tHostname\tClient ID")
-	fmt.Fprintln(writer, "-----------\t-----------\t----------\t--------\t---------")
+	// Parse the Unix timestamp (first field)
+	expiryTimestampUnix, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return LeaseEntry{}, fmt.Errorf("Error parsing timestamp '%s': %v", fields[0], err)
+	}
 
-	// Print each lease entry
-	for _, lease := range leases {
-		// Format the time into a readable string (YYYY-MM-DD HH:MM:SS)
-		// The reference time `2006-01-02 15:04:05` is Go's standard way to define formats.
-		formattedTime := lease.ExpiryTime.Format("2006-01-02 15:04:05")
+	// dnsmasq writes 0 for infinite leases; keep the zero time.Time for those
+	var expiryTime time.Time
+	permanent := expiryTimestampUnix == 0
+	if !permanent {
+		// Convert Unix timestamp (seconds, or milliseconds per --timestamp-unit) to time.Time
+		if unit == "ms" || unit == "" && expiryTimestampUnix >= minMilliTimestamp {
+			expiryTime = time.UnixMilli(expiryTimestampUnix)
+		} else {
+			expiryTime = time.Unix(expiryTimestampUnix, 0)
+		}
+	}
 
-		// Print the table row
-		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n",
-			formattedTime,
-			lease.MACAddress,
-			lease.IPAddress,
-			lease.Hostname,
-			lease.ClientID,
-		)
+	// Create a LeaseEntry record
+	lease := LeaseEntry{
+		ExpiryTime: expiryTime,
+		MACAddress: fields[1],
+		IPAddress:  fields[2],
+		Hostname:   fields[3],
+		ClientID:   fields[4],
+		Permanent:  permanent,
 	}
+	if expectedFields == 6 {
+		lease.Tags = fields[5]
+	}
+	return lease, nil
+}
 
-	// Flush the tabwriter buffer, writing the formatted table to stdout
-	writer.Flush()
+// ParseWarning describes a lease file line that could not be parsed
+type ParseWarning struct {
+	Line int    // 1-based line number
+	Text string // The line as read
+	Err  error  // Why it could not be parsed
+}
+
+func (w *ParseWarning) Error() string {
+	return fmt.Sprintf("line %d: %v", w.Line, w.Err)
+}
+
+// ParseOptions control how lease lines are interpreted. The zero value reads standard
+// dnsmasq files: 5 fields, timestamps in seconds (or milliseconds when 13 digits long),
+// MAC addresses in lower case.
+type ParseOptions struct {
+	TagsColumn    string // "yes" for a 6th tags field, "auto" to accept 5 or 6 per line, else strict 5 fields
+	TimestampUnit string // "s" or "ms"; empty detects milliseconds per value
+	UnknownTokens string // Comma-separated hostname/client ID values meaning "unknown", normalized to *
+	MACCase       string // Letter case of MAC addresses, see NormalizeMAC ("lower" when empty)
+}
+
+// LeaseScanner reads leases one at a time, like bufio.Scanner, so that very large
+// lease files can be processed without holding every entry in memory:
+//
+//	scanner := NewLeaseScanner(file)
+//	scanner.Options = ParseOptions{TimestampUnit: "s"} // Optional
+//	for scanner.Scan() {
+//		if warning := scanner.Warning(); warning != nil {
+//			continue // Malformed line
+//		}
+//		use(scanner.Lease())
+//	}
+//	if err := scanner.Err(); err != nil { ... }
+//
+// Blank lines and # comments are skipped. It is the parser behind every lease file
+// reader of this tool.
+type LeaseScanner struct {
+	Options ParseOptions // Set before the first call to Scan
+
+	scanner *bufio.Scanner
+	line    int // Lines read so far, including blank and comment lines
+	lease   LeaseEntry
+	warning *ParseWarning
+}
+
+// NewLeaseScanner returns a LeaseScanner reading from r
+func NewLeaseScanner(r io.Reader) *LeaseScanner {
+	return &LeaseScanner{scanner: bufio.NewScanner(r)}
+}
+
+// Scan advances to the next lease line, which is available through Lease, or through
+// Warning if it is malformed. It returns false at the end of the input or on a read error.
+func (s *LeaseScanner) Scan() bool {
+	for s.scanner.Scan() {
+		s.line++
+		line := s.scanner.Text()
+		if isBlankOrComment(line) {
+			continue
+		}
+		expectedFields := 5
+		switch s.Options.TagsColumn {
+		case "yes":
+			expectedFields = 6
+		case "auto":
+			if len(strings.Fields(line)) == 6 {
+				expectedFields = 6
+			}
+		}
+		lease, err := parseLeaseLine(line, expectedFields, s.Options.TimestampUnit)
+		if err != nil {
+			s.lease, s.warning = LeaseEntry{}, &ParseWarning{Line: s.line, Text: line, Err: err}
+		} else {
+			normalizeLease(&lease, s.Options)
+			s.lease, s.warning = lease, nil
+		}
+		return true
+	}
+	return false
+}
+
+// Lease returns the lease read by the last call to Scan (the zero LeaseEntry if Warning is not nil)
+func (s *LeaseScanner) Lease() LeaseEntry { return s.lease }
+
+// Warning returns why the line read by the last call to Scan is malformed, or nil
+func (s *LeaseScanner) Warning() *ParseWarning { return s.warning }
+
+// Err returns the first read error, or nil at a clean end of the input
+func (s *LeaseScanner) Err() error { return s.scanner.Err() }
+
+// leaseFilePaths resolves the lease files to read from --file, --dir, the environment, or the default
+// Each --file (or $DNSMASQ_LEASES) may be a glob such as /var/lib/dnsmasq/*.leases.
+func leaseFilePaths(opts options) ([]string, error) {
+	var paths []string
+	for _, file := range opts.Files {
+		matches, err := expandLeaseGlob(file, opts.ReadTimeout)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	if opts.Dir != "" {
+		dir, err := ExpandPath(opts.Dir)
+		if err != nil {
+			return nil, err
+		}
+		pattern := filepath.Join(dir, "*.leases")
+		matches, err := withReadTimeout(opts.ReadTimeout, "listing "+dir, func() ([]string, error) { return filepath.Glob(pattern) })
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no *.leases files found in %s", opts.Dir)
+		}
+		paths = append(paths, matches...)
+	}
+	if len(paths) > 0 {
+		return paths, nil
+	}
+
+	// Fall back to the environment variable, then the default path
+	leaseFilePath := os.Getenv(envVarLeasePath)
+	if leaseFilePath == "" {
+		leaseFilePath = defaultLeaseFilePath
+		slog.Info("environment variable not set, using the default lease file", "variable", envVarLeasePath, "file", defaultLeaseFilePath)
+	} else {
+		slog.Info("using the lease file from the environment", "variable", envVarLeasePath, "file", leaseFilePath)
+	}
+	return expandLeaseGlob(leaseFilePath, opts.ReadTimeout)
+}
+
+// isGlob reports whether the path contains glob metacharacters
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// ExpandPath replaces a leading ~ (alone or followed by a separator) with the home directory,
+// for paths that did not go through a shell, such as those from environment variables.
+// Other paths, including ~user, are returned unchanged.
+func ExpandPath(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("expanding %s: %w", path, err)
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// expandLeaseGlob returns the files matching a glob pattern, or the path itself if it is not a glob.
+// A leading ~ is expanded first. Listing the directories gives up after timeout (0: never).
+func expandLeaseGlob(pattern string, timeout time.Duration) ([]string, error) {
+	pattern, err := ExpandPath(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if !isGlob(pattern) {
+		return []string{pattern}, nil
+	}
+	matches, err := withReadTimeout(timeout, "expanding "+pattern, func() ([]string, error) { return filepath.Glob(pattern) })
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid lease file pattern %q: %w", pattern, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no lease files match %s", pattern)
+	}
+	return matches, nil
+}
+
+// staleLeaseFiles warns about every lease file not modified within maxAge, which can mean
+// dnsmasq died or stopped writing it, and reports whether there was any
+func staleLeaseFiles(paths []string, maxAge time.Duration, now time.Time, timeout time.Duration) bool {
+	stale := false
+	for _, path := range paths {
+		info, err := statTimeout(path, timeout)
+		if err != nil {
+			continue // Reported when the file is read
+		}
+		if age := now.Sub(info.ModTime()); age > maxAge {
+			slog.Warn("lease file has not changed for longer than --max-age", "file", path, "age", FormatDuration(age), "max_age", maxAge)
+			stale = true
+		}
+	}
+	return stale
+}
+
+// warnFutureLeases warns about every lease expiring more than threshold after now, which
+// points to clock skew, a corrupted timestamp or a misconfigured lease time
+func warnFutureLeases(leases []LeaseEntry, threshold time.Duration, now time.Time) {
+	for _, lease := range leases {
+		if !lease.Permanent && lease.ExpiryTime.Sub(now) > threshold {
+			slog.Warn("lease expires implausibly far in the future", "mac", lease.MACAddress, "ip", lease.IPAddress,
+				"expiry", lease.ExpiryTime.Format(time.RFC3339), "in", FormatDuration(lease.ExpiryTime.Sub(now)))
+		}
+	}
+}
+
+// readSeenMACs returns the MACs recorded in a --state-file, one per line.
+// A missing file means there was no previous run and yields an empty set.
+func readSeenMACs(path string) (map[string]bool, error) {
+	seen := make(map[string]bool)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return seen, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			seen[NormalizeMAC(line, "lower")] = true
+		}
+	}
+	return seen, nil
+}
+
+// writeSeenMACs records the MACs of leases in a --state-file, sorted one per line
+func writeSeenMACs(path string, leases []LeaseEntry) error {
+	seen := make(map[string]bool)
+	for _, lease := range leases {
+		seen[NormalizeMAC(lease.MACAddress, "lower")] = true
+	}
+	macs := make([]string, 0, len(seen))
+	for mac := range seen {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+
+	var data bytes.Buffer
+	for _, mac := range macs {
+		fmt.Fprintln(&data, mac)
+	}
+	if err := writeFileAtomic(path, data.Bytes()); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place,
+// so an interrupted run never leaves a truncated file behind
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// newSinceLast returns the leases whose MAC was not recorded by the previous run in
+// the state file, and records the MACs of all given leases for the next run. On the
+// first run (no or an empty state file) every lease is new. It expects the parsed
+// leases; filters are applied to its result, not to what it records.
+func newSinceLast(leases []LeaseEntry, stateFile string) ([]LeaseEntry, error) {
+	seen, err := readSeenMACs(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	var fresh []LeaseEntry
+	for _, lease := range leases {
+		if !seen[NormalizeMAC(lease.MACAddress, "lower")] {
+			fresh = append(fresh, lease)
+		}
+	}
+	if err := writeSeenMACs(stateFile, leases); err != nil {
+		return nil, err
+	}
+	slog.Debug("compared with previous run", "state_file", stateFile, "seen", len(seen), "new", len(fresh))
+	return fresh, nil
+}
+
+// progressWidth is the length of the --progress bar
+const progressWidth = 30
+
+// progress draws a bar with a counter on stderr, redrawn in place on every step.
+// A nil progress does nothing, so callers need not check whether it is enabled.
+type progress struct {
+	w     io.Writer
+	label string
+	done  int
+	total int
+}
+
+// newProgress returns a progress bar over total steps, or nil if it is disabled,
+// there is nothing to do, or stderr is not a terminal (e.g. redirected to a log file)
+func newProgress(enabled bool, label string, total int) *progress {
+	if !enabled || total == 0 {
+		return nil
+	}
+	if info, err := os.Stderr.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	p := &progress{w: os.Stderr, label: label, total: total}
+	p.draw()
+	return p
+}
+
+func (p *progress) draw() {
+	filled := p.done * progressWidth / p.total
+	fmt.Fprintf(p.w, "\r%s [%s%s] %d/%d", p.label, strings.Repeat("#", filled), strings.Repeat(" ", progressWidth-filled), p.done, p.total)
+}
+
+// Step records one finished step
+func (p *progress) Step() {
+	if p == nil {
+		return
+	}
+	p.done = min(p.done+1, p.total)
+	p.draw()
+}
+
+// Done erases the bar, leaving stderr as it was
+func (p *progress) Done() {
+	if p == nil {
+		return
+	}
+	fmt.Fprint(p.w, "\r\x1b[K")
+}
+
+// parsedFile is the result of parsing the index-th of several lease files
+type parsedFile struct {
+	index   int
+	entries []LeaseEntry
+	err     error
+}
+
+// parseLeaseFiles parses and merges several lease files, recording the source of each entry.
+// Up to --parallel files are parsed concurrently; the leases keep the order of paths, and
+// every file that failed is reported, not just the first.
+func parseLeaseFiles(paths []string, opts options) ([]LeaseEntry, error) {
+	results := make(chan parsedFile, len(paths))
+	slots := make(chan struct{}, max(opts.Parallel, 1))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			entries, err := parseLeaseFile(path, opts)
+			results <- parsedFile{index: i, entries: entries, err: err}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	bar := newProgress(opts.Progress && len(paths) > 1, "Reading lease files", len(paths))
+	parsed := make([]parsedFile, len(paths))
+	for result := range results {
+		parsed[result.index] = result
+		bar.Step()
+	}
+	bar.Done()
+	total := 0
+	for _, result := range parsed {
+		total += len(result.entries)
+	}
+	leases := make([]LeaseEntry, 0, total)
+	var errs []error
+	for i, result := range parsed {
+		if result.err != nil {
+			errs = append(errs, result.err)
+			continue
+		}
+		for j := range result.entries {
+			result.entries[j].Source = paths[i]
+		}
+		leases = append(leases, result.entries...)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return leases, nil
+}
+
+// normalizeLease applies the ParseOptions spelling rules to a parsed lease: unknown
+// tokens become "*" and the MAC address is written in colon notation in MACCase
+func normalizeLease(lease *LeaseEntry, opts ParseOptions) {
+	normalizeUnknown(lease, opts.UnknownTokens)
+	lease.MACAddress = NormalizeMAC(lease.MACAddress, opts.MACCase)
+}
+
+// normalizeUnknown rewrites a hostname or client ID matching one of the comma-separated
+// tokens to "*", the way dnsmasq writes them, so forks using "-" look the same everywhere
+func normalizeUnknown(lease *LeaseEntry, tokens string) {
+	for _, token := range strings.Split(tokens, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		if lease.Hostname == token {
+			lease.Hostname = "*"
+		}
+		if lease.ClientID == token {
+			lease.ClientID = "*"
+		}
+	}
+}
+
+// minMilliTimestamp is the smallest 13-digit timestamp: in seconds it would be
+// after the year 33000, in milliseconds it is September 2001
+const minMilliTimestamp = 1_000_000_000_000
+
+// hasMilliTimestamps reports whether the first finite expiry in the file is obviously in milliseconds
+func hasMilliTimestamps(lines []string) bool {
+	for _, line := range lines {
+		if isBlankOrComment(line) {
+			continue
+		}
+		ts, err := strconv.ParseInt(strings.Fields(line)[0], 10, 64)
+		if err != nil || ts == 0 {
+			continue // Malformed or infinite, the next line may tell
+		}
+		return ts >= minMilliTimestamp
+	}
+	return false
+}
+
+// isBlankOrComment reports whether a lease file line is empty or a # comment, as found in
+// hand-edited files; such lines are skipped silently
+func isBlankOrComment(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, "#")
+}
+
+// hasTagsColumn reports whether every non-blank, non-comment line has exactly 6 fields,
+// which is how patched dnsmasq builds write an extra tags column
+func hasTagsColumn(lines []string) bool {
+	found := false
+	for _, line := range lines {
+		if isBlankOrComment(line) {
+			continue
+		}
+		if len(strings.Fields(line)) != 6 {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// anonymizeMAC maps a MAC address to a MAC-like string derived from SHA-256(salt || mac).
+// The result is marked locally administered so it cannot be mistaken for a vendor address.
+func anonymizeMAC(mac string, salt []byte) string {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(strings.ToLower(mac)))
+	sum := h.Sum(nil)[:6]
+	sum[0] = sum[0]&0xfc | 0x02 // Locally administered, unicast
+	return net.HardwareAddr(sum).String()
+}
+
+// anonymizeMACs replaces every MAC address in place, including copies embedded in the
+// client ID, writing them in macCase like parsed MACs. The embedded copy is matched in
+// either case and the rest of the client ID is kept as is.
+func anonymizeMACs(leases []LeaseEntry, salt []byte, macCase string) {
+	for i := range leases {
+		original := leases[i].MACAddress
+		anonymized := anonymizeMAC(original, salt)
+		leases[i].MACAddress = NormalizeMAC(anonymized, macCase)
+		embedded := regexp.MustCompile("(?i)" + regexp.QuoteMeta(original))
+		leases[i].ClientID = embedded.ReplaceAllLiteralString(leases[i].ClientID, anonymized)
+	}
+}
+
+// anonymizeToken hashes an identifier with the salt into a short prefixed token, keeping "*" as is
+func anonymizeToken(prefix, value string, salt []byte) string {
+	if value == "*" || value == "" {
+		return value // Unknown stays unknown so counts of unnamed devices survive
+	}
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(prefix))
+	h.Write([]byte(value))
+	return prefix + hex.EncodeToString(h.Sum(nil)[:6])
+}
+
+// anonymizeLeases replaces every identifying field in place with salted hashes.
+// Equal inputs map to equal outputs within one salt, so records stay countable and joinable.
+// MACs are written in macCase like parsed ones.
+func anonymizeLeases(leases []LeaseEntry, salt []byte, bucketIPs bool, macCase string) {
+	for i := range leases {
+		lease := &leases[i]
+		lease.MACAddress = NormalizeMAC(anonymizeMAC(lease.MACAddress, salt), macCase)
+		lease.Hostname = anonymizeToken("host-", strings.ToLower(lease.Hostname), salt)
+		lease.ClientID = anonymizeToken("id-", strings.ToLower(lease.ClientID), salt)
+		if bucketIPs {
+			if addr, err := netip.ParseAddr(lease.IPAddress); err == nil {
+				bits := 24
+				if addr.Unmap().Is6() {
+					bits = 64
+				}
+				prefix, _ := addr.Unmap().Prefix(bits)
+				lease.IPAddress = prefix.String()
+			}
+		}
+	}
+}
+
+// leaseTags splits the tags column of a lease into individual tags
+func leaseTags(lease LeaseEntry) []string {
+	if lease.Tags == "" || lease.Tags == "*" {
+		return nil
+	}
+	return strings.Split(lease.Tags, ",")
+}
+
+// filterLeases applies the filter flags and returns the leases to display
+func filterLeases(leases []LeaseEntry, opts options) []LeaseEntry {
+	now := clock()
+	var filtered []LeaseEntry
+	for _, lease := range leases {
+		if opts.Tag != "" && !hasAnyTag(lease, strings.Split(opts.Tag, ",")) {
+			continue
+		}
+		if opts.Active && !lease.Active(now) {
+			continue
+		}
+		// --format resolv-conf selects by --hostname or --dns-server-mac itself
+		if opts.Hostname != "" && opts.Format != "resolv-conf" && !hostnameMatches(opts.Hostname, lease.Hostname) {
+			continue
+		}
+		if opts.IPv4Only || opts.IPv6Only {
+			// Unparsable addresses belong to neither family
+			addr, err := netip.ParseAddr(lease.IPAddress)
+			if err != nil || (opts.IPv4Only && !addr.Unmap().Is4()) || (opts.IPv6Only && !addr.Unmap().Is6()) {
+				continue
+			}
+		}
+		if opts.MinExpiry > 0 && !lease.Permanent && lease.ExpiryTime.Sub(now) < opts.MinExpiry {
+			continue
+		}
+		// Like --expire-in, --max-expiry excludes its bound: --min-expiry A --max-expiry B keeps [A, B)
+		if opts.MaxExpiry > 0 && (lease.Permanent || lease.ExpiryTime.Sub(now) >= opts.MaxExpiry) {
+			continue
+		}
+		if opts.ExpireIn > 0 && (lease.Permanent || !lease.Active(now) || lease.ExpiryTime.Sub(now) >= opts.ExpireIn) {
+			continue
+		}
+		if opts.Vendor != "" && !strings.Contains(strings.ToLower(vendorOrUnknown(lease.MACAddress)), strings.ToLower(opts.Vendor)) {
+			continue
+		}
+		if opts.HideUnknown && lease.Hostname == "*" {
+			continue
+		}
+		if (opts.HideRandom || opts.OnlyRandom) && isRandomMAC(lease.MACAddress) != opts.OnlyRandom {
+			continue
+		}
+		if opts.rangeLo.IsValid() {
+			addr, err := netip.ParseAddr(lease.IPAddress)
+			if err != nil {
+				continue
+			}
+			addr = addr.Unmap()
+			if addr.BitLen() != opts.rangeLo.BitLen() || addr.Less(opts.rangeLo) || opts.rangeHi.Less(addr) {
+				continue
+			}
+		}
+		if opts.subnet.IsValid() {
+			addr, err := netip.ParseAddr(lease.IPAddress)
+			if err != nil || !opts.subnet.Contains(addr.Unmap()) {
+				continue
+			}
+		}
+		filtered = append(filtered, lease)
+	}
+	return filtered
+}
+
+// hostnameMatches reports whether the hostname matches the --hostname glob, ignoring case
+func hostnameMatches(pattern, hostname string) bool {
+	// The pattern was validated in parseFlags, so Match cannot fail here
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(hostname))
+	return ok
+}
+
+// leasesOfBusyMACs keeps the leases of MACs holding more than limit leases, e.g. one
+// device given addresses on several interfaces or by several dnsmasq instances
+func leasesOfBusyMACs(leases []LeaseEntry, limit int) []LeaseEntry {
+	perMAC := LeasesByMAC(leases)
+	var busy []LeaseEntry
+	for _, lease := range leases {
+		if len(perMAC[NormalizeMAC(lease.MACAddress, "lower")]) > limit {
+			busy = append(busy, lease)
+		}
+	}
+	return busy
+}
+
+// LeasesByMAC groups leases by MAC address, keyed by the lower-case colon notation of
+// NormalizeMAC so differently written MACs of one device share a group. Each group keeps
+// the order of leases, i.e. file order for parsed leases; callers may re-sort.
+func LeasesByMAC(leases []LeaseEntry) map[string][]LeaseEntry {
+	groups := make(map[string][]LeaseEntry)
+	for _, lease := range leases {
+		key := NormalizeMAC(lease.MACAddress, "lower")
+		groups[key] = append(groups[key], lease)
+	}
+	return groups
+}
+
+// LeasesByHostname groups leases by hostname, keyed by the lower-cased name since DNS
+// names are case-insensitive. Leases without a hostname (*) are left out. Each group
+// keeps the order of leases, i.e. file order for parsed leases; callers may re-sort.
+func LeasesByHostname(leases []LeaseEntry) map[string][]LeaseEntry {
+	groups := make(map[string][]LeaseEntry)
+	for _, lease := range leases {
+		if lease.Hostname == "*" {
+			continue
+		}
+		key := strings.ToLower(lease.Hostname)
+		groups[key] = append(groups[key], lease)
+	}
+	return groups
+}
+
+// sampleLeases returns n leases chosen uniformly at random (all of them, shuffled, if there
+// are fewer), in the random order they were drawn
+func sampleLeases(leases []LeaseEntry, n int, seed int64) []LeaseEntry {
+	rng := mathrand.New(mathrand.NewSource(seed))
+	sample := slices.Clone(leases)
+	n = min(n, len(sample))
+	for i := range n {
+		// Partial Fisher-Yates shuffle: only the first n positions are needed
+		j := i + rng.Intn(len(sample)-i)
+		sample[i], sample[j] = sample[j], sample[i]
+	}
+	return sample[:n]
+}
+
+// dedupeByIP keeps the latest-expiring lease of every IP address (permanent leases win),
+// breaking ties by the lowest MAC address, and logs how many stale duplicates were dropped.
+// The surviving leases keep their original order.
+func dedupeByIP(leases []LeaseEntry) []LeaseEntry {
+	best := map[string]int{} // Canonical IP -> index of the lease to keep
+	for i, lease := range leases {
+		key := lease.IPAddress
+		if addr, err := netip.ParseAddr(key); err == nil {
+			key = addr.Unmap().String()
+		}
+		j, ok := best[key]
+		if !ok {
+			best[key] = i
+			continue
+		}
+		c := compareLeases(lease, leases[j], "expiry", nil)
+		if c == 0 {
+			c = -compareLeases(lease, leases[j], "mac", nil)
+		}
+		if c > 0 {
+			best[key] = i
+		}
+	}
+	keep := make([]bool, len(leases))
+	for _, i := range best {
+		keep[i] = true
+	}
+	var deduped []LeaseEntry
+	for i, lease := range leases {
+		if keep[i] {
+			deduped = append(deduped, lease)
+		}
+	}
+	if removed := len(leases) - len(deduped); removed > 0 {
+		slog.Info("dropped duplicate leases of the same IP", "removed", removed)
+	}
+	return deduped
+}
+
+// hasAnyTag reports whether the lease carries at least one of the wanted tags
+func hasAnyTag(lease LeaseEntry, wanted []string) bool {
+	for _, tag := range leaseTags(lease) {
+		for _, w := range wanted {
+			if strings.EqualFold(tag, strings.TrimSpace(w)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// formatExpiry renders the expiry column of a lease
+func formatExpiry(lease LeaseEntry) string {
+	if lease.Permanent {
+		return "PERMANENT" // Infinite leases have no expiry time
+	}
+	// Format the time into a readable string (YYYY-MM-DD HH:MM:SS)
+	// The reference time `2006-01-02 15:04:05` is Go's standard way to define formats.
+	return lease.ExpiryTime.Format("2006-01-02 15:04:05")
+}
+
+// leaseField documents one parsed or computed field of a lease, for --list-fields
+type leaseField struct {
+	Name        string               // Canonical field name (JSON key for parsed fields)
+	Description string               // One-line description
+	Flag        string               // Flag that adds the field to the output; empty for parsed fields
+	Enabled     func(options) bool   // Whether the field is part of the output with the given flags
+	Available   func(options) string // Empty when the field can be computed, otherwise what is missing
+}
+
+// always is the Enabled/Available function of fields that need nothing
+func always(options) bool { return true }
+
+// leaseFields lists every field the tool knows about, parsed fields first
+var leaseFields = []leaseField{
+	{Name: "expiry_time", Description: "Lease expiration time (PERMANENT for infinite leases)", Enabled: func(o options) bool { return o.Format != "table" || !o.Remaining || o.ShowBoth }},
+	{Name: "mac_address", Description: "Client MAC address", Enabled: always},
+	{Name: "ip_address", Description: "Assigned IPv4 or IPv6 address", Enabled: always},
+	{Name: "hostname", Description: "Client hostname, * when unknown", Enabled: always},
+	{Name: "client_id", Description: "DHCP client identifier, * when absent", Enabled: always},
+	{Name: "permanent", Description: "True for infinite leases (timestamp 0)", Enabled: func(o options) bool { return o.Format == "json" }},
+	{Name: "tags", Description: "Tags from a 6th column, when the file has one", Enabled: func(o options) bool { return o.TagsColumn != "no" }},
+	{Name: "source", Description: "Lease file the entry was read from", Flag: "--source", Enabled: func(o options) bool { return o.Source || o.Format == "json" }},
+	{Name: "remaining", Description: "Time left until expiry", Flag: "--remaining / --show-both", Enabled: func(o options) bool { return o.Remaining || o.ShowBoth }},
+	{Name: "age", Description: "Time since the lease was granted", Flag: "--age-column", Enabled: func(o options) bool { return o.AgeColumn },
+		Available: func(o options) string {
+			if o.LeaseDuration <= 0 {
+				return "needs --lease-duration"
+			}
+			return ""
+		}},
+	{Name: "start_time", Description: "Last DHCPACK for the lease in the dnsmasq log", Flag: "--log", Enabled: func(o options) bool { return o.Log != "" }},
+	{Name: "lease_time", Description: "Expiry minus start time from the log", Flag: "--log", Enabled: func(o options) bool {
+		return o.Log != "" && (o.Format == "table" || strings.HasPrefix(o.Format, "csv"))
+	}},
+	{Name: "random", Description: "Whether the MAC is privacy-randomized (locally administered bit set)", Flag: "--detect-random", Enabled: func(o options) bool { return o.DetectRandom }},
+	{Name: "vendor", Description: "Manufacturer from the MAC address prefix (built-in table or --vendor-file)", Flag: "--show-vendor", Enabled: func(o options) bool { return o.ShowVendor }},
+	{Name: "subnet", Description: "Network of the IP address with --cidr-prefix-length", Flag: "--cidr-column", Enabled: func(o options) bool { return o.CIDRColumn }},
+	{Name: "hash", Description: "SHA-256 of the normalized lease fields", Flag: "--hash", Enabled: func(o options) bool { return o.Hash }},
+	{Name: "client_id_type", Description: "Interpretation of the client identifier", Flag: "--decode-client-id", Enabled: func(o options) bool { return o.DecodeClientID }},
+	{Name: "reverse_dns", Description: "PTR names of the IP address", Flag: "--resolve", Enabled: func(o options) bool {
+		return o.Resolve && (o.Format == "table" || strings.HasPrefix(o.Format, "csv"))
+	}},
+}
+
+// printFieldList writes the known fields and whether each is part of the output with the current flags
+func printFieldList(w io.Writer, opts options) error {
+	writer := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "Field\tEnabled\tFlag\tDescription")
+	fmt.Fprintln(writer, "-----\t-------\t----\t-----------")
+	for _, field := range leaseFields {
+		enabled := "no"
+		if len(opts.columns) > 0 && indexOf(columnNames, field.Name) >= 0 {
+			if indexOf(opts.columns, field.Name) >= 0 {
+				enabled = "yes"
+			}
+		} else if field.Enabled(opts) {
+			enabled = "yes"
+		}
+		if field.Available != nil {
+			if missing := field.Available(opts); missing != "" {
+				enabled = missing
+			}
+		}
+		flagName := field.Flag
+		if flagName == "" {
+			flagName = "-"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", field.Name, enabled, flagName, field.Description)
+	}
+	return writer.Flush()
+}
+
+// tableColumn is one column of the text table
+type tableColumn struct {
+	Name   string                  // Field name accepted by --columns (see leaseFields)
+	Header string                  // Column title
+	Value  func(LeaseEntry) string // Cell value for a lease
+}
+
+// columnNames lists the values accepted by --columns, in default display order
+var columnNames = []string{
+	"expiry_time", "remaining", "mac_address", "ip_address", "hostname", "client_id", "tags", "age",
+	"start_time", "lease_time", "source", "client_id_type", "random", "vendor", "subnet", "reverse_dns", "hash",
+}
+
+// tableColumns returns the columns to print, in order: those named by --columns, or the
+// default set for the given flags. Column order never depends on --sort.
+func tableColumns(leases []LeaseEntry, opts options) []tableColumn {
+	now := clock()
+	leaseDuration := time.Duration(opts.LeaseDuration) * time.Second
+	all := []tableColumn{
+		{"expiry_time", "Expiry Time", formatExpiry},
+		{"remaining", "Remaining", func(l LeaseEntry) string { return formatRemaining(l, now) }},
+		{"mac_address", "MAC Address", func(l LeaseEntry) string { return l.MACAddress }},
+		{"ip_address", "IP Address", func(l LeaseEntry) string { return l.IPAddress }},
+		{"hostname", "Hostname", func(l LeaseEntry) string { return l.Hostname }},
+		{"client_id", "Client ID", func(l LeaseEntry) string { return l.ClientID }},
+		{"tags", "Tags", func(l LeaseEntry) string { return l.Tags }},
+		{"age", "Age", func(l LeaseEntry) string {
+			if l.Permanent {
+				return "-"
+			}
+			return FormatDuration(now.Sub(l.GrantedAt(leaseDuration)))
+		}},
+		{"start_time", "Start", func(l LeaseEntry) string {
+			if l.StartTime.IsZero() {
+				return "-"
+			}
+			return l.StartTime.Format("2006-01-02 15:04:05")
+		}},
+		{"lease_time", "Lease Time", func(l LeaseEntry) string {
+			if l.StartTime.IsZero() || l.Permanent {
+				return "-"
+			}
+			return FormatDuration(l.ExpiryTime.Sub(l.StartTime))
+		}},
+		{"source", "Source", func(l LeaseEntry) string { return l.Source }},
+		{"client_id_type", "Client ID Type", func(l LeaseEntry) string { return decodeClientID(l.ClientID) }},
+		{"random", "Random", func(l LeaseEntry) string {
+			if isRandomMAC(l.MACAddress) {
+				return "yes"
+			}
+			return "no"
+		}},
+		{"vendor", "Vendor", func(l LeaseEntry) string { return vendorOrUnknown(l.MACAddress) }},
+		{"subnet", "Subnet", func(l LeaseEntry) string { return cidrSubnet(l.IPAddress, opts.CIDRPrefixLen) }},
+		{"reverse_dns", "Reverse DNS", func(l LeaseEntry) string { return opts.reverseNames[l.IPAddress] }},
+		{"hash", "Hash", LeaseEntry.Hash},
+	}
+
+	names := opts.columns
+	if len(names) == 0 {
+		names = defaultColumns(leases, opts)
+	}
+	columns := make([]tableColumn, 0, len(names))
+	for _, name := range names {
+		columns = append(columns, all[indexOf(columnNames, name)])
+	}
+	return columns
+}
+
+// defaultColumns returns the names of the columns shown when --columns is not given
+func defaultColumns(leases []LeaseEntry, opts options) []string {
+	var names []string
+	switch {
+	case opts.ShowBoth:
+		names = append(names, "expiry_time", "remaining")
+	case opts.Remaining:
+		names = append(names, "remaining")
+	default:
+		names = append(names, "expiry_time")
+	}
+	names = append(names, "mac_address", "ip_address", "hostname", "client_id")
+
+	// The Tags column is only shown for files that have one
+	for _, lease := range leases {
+		if lease.Tags != "" {
+			names = append(names, "tags")
+			break
+		}
+	}
+	if opts.AgeColumn {
+		names = append(names, "age")
+	}
+	if opts.Log != "" {
+		names = append(names, "start_time", "lease_time")
+	}
+	if opts.Source {
+		names = append(names, "source")
+	}
+	if opts.DecodeClientID {
+		names = append(names, "client_id_type")
+	}
+	if opts.DetectRandom {
+		names = append(names, "random")
+	}
+	if opts.ShowVendor {
+		names = append(names, "vendor")
+	}
+	if opts.CIDRColumn {
+		names = append(names, "subnet")
+	}
+	if opts.Resolve {
+		names = append(names, "reverse_dns")
+	}
+	if opts.Hash {
+		names = append(names, "hash")
+	}
+	return names
+}
+
+// runeWidth returns the number of terminal columns a rune occupies: 0 for combining and
+// format characters, 2 for characters Unicode classifies as East Asian wide or fullwidth
+// (CJK, Hangul, kana, fullwidth forms, emoji), 1 otherwise
+func runeWidth(r rune) int {
+	if unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+// displayWidth returns the number of terminal columns s occupies
+func displayWidth(s string) int {
+	ascii := true
+	for i := 0; i < len(s) && ascii; i++ {
+		ascii = s[i] >= 0x20 && s[i] < 0x7f
+	}
+	if ascii {
+		return len(s) // The common case, one column per byte
+	}
+	width := 0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// writeAligned writes rows of cells as left-aligned columns separated by at least
+// padding spaces. Unlike tabwriter, which counts runes, it pads by display width, so
+// columns after a CJK hostname still line up on a terminal.
+func writeAligned(w io.Writer, rows [][]string, padding int) error {
+	var widths []int
+	for _, cells := range rows {
+		for i, cell := range cells[:max(len(cells)-1, 0)] { // The last cell is never padded
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], displayWidth(cell))
+		}
+	}
+	lineWidth := 1
+	for _, width := range widths {
+		lineWidth += width + padding
+	}
+	line := make([]byte, 0, lineWidth+64) // Room for a typical last cell and multibyte characters
+	for _, cells := range rows {
+		line = line[:0]
+		for i, cell := range cells {
+			line = append(line, cell...)
+			if i < len(cells)-1 {
+				for n := widths[i] - displayWidth(cell) + padding; n > 0; n-- {
+					line = append(line, ' ')
+				}
+			}
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printTable writes the leases as an aligned text table
+func printTable(w io.Writer, leases []LeaseEntry, opts options) error {
+	columns := tableColumns(leases, opts)
+	rows := make([][]string, 0, len(leases)+2)
+
+	// Table header, underlining each title with --separator-line characters of the same width
+	if !opts.NoHeader {
+		header := make([]string, len(columns))
+		for i, column := range columns {
+			header[i] = column.Header
+		}
+		rows = append(rows, header)
+		if !opts.NoSeparatorLine {
+			// A wide separator such as '＝' covers two columns per character
+			separatorWidth := max(displayWidth(opts.SeparatorLine), 1)
+			underline := make([]string, len(columns))
+			for i, column := range columns {
+				underline[i] = strings.Repeat(opts.SeparatorLine, max(displayWidth(column.Header)/separatorWidth, 1))
+			}
+			rows = append(rows, underline)
+		}
+	}
+
+	// One row per lease entry, all cells in a single allocation
+	cells := make([]string, len(leases)*len(columns))
+	for n, lease := range leases {
+		row := cells[n*len(columns) : (n+1)*len(columns) : (n+1)*len(columns)]
+		for i, column := range columns {
+			row[i] = column.Value(lease)
+		}
+		rows = append(rows, row)
+	}
+
+	// tabwriter counts runes, which misaligns the columns after a CJK or emoji hostname,
+	// so only a table with such cells is padded by display width
+	for _, row := range rows {
+		for _, cell := range row {
+			if displayWidth(cell) != utf8.RuneCountInString(cell) {
+				return writeAligned(w, rows, 2)
+			}
+		}
+	}
+
+	// Use tabwriter for nicely formatted columns
+	// Parameters: output io.Writer, minwidth, tabwidth, padding, padchar, flags
+	writer := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	// Print each row, reusing one line buffer instead of joining strings per row
+	var line []byte
+	for _, row := range rows {
+		line = line[:0]
+		for i, cell := range row {
+			if i > 0 {
+				line = append(line, '\t')
+			}
+			line = append(line, cell...)
+		}
+		writer.Write(append(line, '\n'))
+	}
+
+	// Flush the tabwriter buffer, writing the formatted table to the output
+	return writer.Flush()
+}
+
+// groupKeys are the accepted --group-by values
+var groupKeys = []string{"vendor", "subnet"}
+
+// leaseGroup is the leases sharing one --group-by value
+type leaseGroup struct {
+	Name   string
+	Subnet netip.Prefix // Set when grouping by subnet, for ordering
+	Leases []LeaseEntry
+}
+
+// groupLeases splits the leases by vendor (Unknown when not in the table) or by subnet
+// (the first --pool containing the address, else its /24 or /64). Groups are ordered by
+// name or address, with Unknown last; each keeps the order of its leases.
+func groupLeases(leases []LeaseEntry, by string, pools []netip.Prefix) []leaseGroup {
+	index := map[string]int{}
+	var groups []leaseGroup
+	for _, lease := range leases {
+		group := leaseGroup{Name: "Unknown"}
+		switch by {
+		case "vendor":
+			group.Name = vendorOrUnknown(lease.MACAddress)
+		case "subnet":
+			if prefix, ok := leaseSubnet(lease.IPAddress, pools); ok {
+				group.Name, group.Subnet = prefix.String(), prefix
+			}
+		}
+		i, ok := index[group.Name]
+		if !ok {
+			i = len(groups)
+			index[group.Name] = i
+			groups = append(groups, group)
+		}
+		groups[i].Leases = append(groups[i].Leases, lease)
+	}
+	slices.SortFunc(groups, func(a, b leaseGroup) int {
+		if (a.Name == "Unknown") != (b.Name == "Unknown") {
+			if a.Name == "Unknown" {
+				return 1
+			}
+			return -1
+		}
+		if a.Subnet.IsValid() && b.Subnet.IsValid() {
+			if c := a.Subnet.Addr().Compare(b.Subnet.Addr()); c != 0 {
+				return c
+			}
+			return a.Subnet.Bits() - b.Subnet.Bits()
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return groups
+}
+
+// printGroupedTable writes one table per --group-by group, each under a "Name (N leases)" heading
+func printGroupedTable(w io.Writer, leases []LeaseEntry, opts options) error {
+	pools, err := parsePools(opts.Pools)
+	if err != nil {
+		return err
+	}
+	for i, group := range groupLeases(leases, opts.GroupBy, pools) {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		noun := "leases"
+		if len(group.Leases) == 1 {
+			noun = "lease"
+		}
+		fmt.Fprintf(w, "%s (%d %s)\n", group.Name, len(group.Leases), noun)
+		if err := printTable(w, group.Leases, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonTime is a time serialized according to --json-time-format
+type jsonTime struct {
+	t      time.Time
+	format string
+}
+
+// IsZero lets omitzero drop unset times
+func (j jsonTime) IsZero() bool { return j.t.IsZero() }
+
+func (j jsonTime) MarshalJSON() ([]byte, error) {
+	switch {
+	case j.format == "unix":
+		return jsonUnix(j.t, time.Time.Unix)
+	case j.format == "unix-milli":
+		return jsonUnix(j.t, time.Time.UnixMilli)
+	case j.format == "rfc850":
+		return json.Marshal(j.t.Format(time.RFC850))
+	case strings.HasPrefix(j.format, "custom:"):
+		return json.Marshal(j.t.Format(strings.TrimPrefix(j.format, "custom:")))
+	default:
+		return j.t.MarshalJSON() // RFC 3339
+	}
+}
+
+// jsonUnix writes a Unix timestamp, using 0 for the zero time like dnsmasq does for infinite leases
+func jsonUnix(t time.Time, unit func(time.Time) int64) ([]byte, error) {
+	if t.IsZero() {
+		return []byte("0"), nil
+	}
+	return strconv.AppendInt(nil, unit(t), 10), nil
+}
+
+// jsonLease is the JSON representation of a lease, with optional computed fields.
+// The time fields shadow those of LeaseEntry to apply --json-time-format.
+type jsonLease struct {
+	ExpiryTime jsonTime `json:"expiry_time"`
+	LeaseEntry
+	StartTime jsonTime `json:"start_time,omitzero"`
+	Random    *bool    `json:"random,omitempty"`
+	Vendor    string   `json:"vendor,omitempty"`
+	Subnet    string   `json:"subnet,omitempty"`
+	Hash      string   `json:"hash,omitempty"`
+}
+
+// columnRow is a lease rendered as a JSON object of the --columns values, in column order
+type columnRow struct {
+	columns []tableColumn
+	lease   LeaseEntry
+}
+
+func (r columnRow) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, column := range r.columns {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(column.Name)
+		value, err := json.Marshal(column.Value(r.lease))
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// printJSON writes the leases as an indented JSON array;
+// with --columns each lease becomes an object of the selected columns' display values.
+func printJSON(w io.Writer, leases []LeaseEntry, opts options) error {
+	if len(opts.columns) > 0 {
+		columns := tableColumns(leases, opts)
+		rows := make([]columnRow, len(leases))
+		for i, lease := range leases {
+			rows[i] = columnRow{columns, lease}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	}
+	out := make([]jsonLease, len(leases))
+	for i, lease := range leases {
+		out[i] = jsonLease{
+			ExpiryTime: jsonTime{lease.ExpiryTime, opts.JSONTimeFormat},
+			LeaseEntry: lease,
+			StartTime:  jsonTime{lease.StartTime, opts.JSONTimeFormat},
+		}
+		if opts.DetectRandom {
+			random := isRandomMAC(lease.MACAddress)
+			out[i].Random = &random
+		}
+		if opts.ShowVendor {
+			out[i].Vendor = vendorOrUnknown(lease.MACAddress)
+		}
+		if opts.CIDRColumn {
+			out[i].Subnet = cidrSubnet(lease.IPAddress, opts.CIDRPrefixLen)
+		}
+		if opts.Hash {
+			out[i].Hash = lease.Hash()
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// FormatDuration renders a duration as "5d 3h 22m 10s", omitting leading zero units,
+// or "EXPIRED" for a negative duration (an expiry in the past)
+func FormatDuration(d time.Duration) string {
+	if d < 0 {
+		return "EXPIRED"
+	}
+	d = d.Round(time.Second)
+	days := int64(d / (24 * time.Hour))
+	hours := int64(d/time.Hour) % 24
+	minutes := int64(d/time.Minute) % 60
+	seconds := int64(d/time.Second) % 60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh %dm %ds", days, hours, minutes, seconds)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm %ds", hours, minutes, seconds)
+	case minutes > 0:
+		return fmt.Sprintf("%dm %ds", minutes, seconds)
+	default:
+		return fmt.Sprintf("%ds", seconds)
+	}
+}
+
+// formatRemaining renders the time left on a lease
+func formatRemaining(lease LeaseEntry, now time.Time) string {
+	if lease.Permanent {
+		return "never expires"
+	}
+	if !lease.Active(now) {
+		return "expired"
+	}
+	return FormatDuration(lease.ExpiryTime.Sub(now))
+}
+
+// decodeClientID interprets a colon-separated hex client identifier.
+// The first byte is the type: 0 for a non-hardware identifier, an ARP hardware
+// type (1 = Ethernet) followed by the hardware address, or 255 for an RFC 4361
+// identifier made of a 4-byte IAID and a DUID.
+func decodeClientID(clientID string) string {
+	if clientID == "" || clientID == "*" {
+		return "-"
+	}
+	var id []byte
+	for _, part := range strings.Split(clientID, ":") {
+		b, err := strconv.ParseUint(part, 16, 8)
+		if err != nil {
+			return "not hex"
+		}
+		id = append(id, byte(b))
+	}
+	if len(id) < 2 {
+		return "invalid"
+	}
+
+	kind, data := id[0], id[1:]
+	switch {
+	case kind == 0:
+		// Type 0 usually carries a printable name
+		for _, b := range data {
+			if b < 0x20 || b > 0x7e {
+				return fmt.Sprintf("opaque (%d bytes)", len(data))
+			}
+		}
+		return fmt.Sprintf("string %q", string(data))
+	case kind == 1 && len(data) == 6:
+		return "Ethernet MAC " + net.HardwareAddr(data).String()
+	case kind == 255:
+		if len(data) < 6 {
+			return "IAID+DUID (truncated)"
+		}
+		iaid := uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+		return fmt.Sprintf("IAID %d, %s", iaid, decodeDUID(data[4:]))
+	default:
+		return fmt.Sprintf("hardware type %d, address %s", kind, net.HardwareAddr(data).String())
+	}
+}
+
+// decodeDUID describes a DHCPv6 DUID (RFC 8415 section 11)
+func decodeDUID(duid []byte) string {
+	if len(duid) < 2 {
+		return "DUID (truncated)"
+	}
+	duidType, data := uint16(duid[0])<<8|uint16(duid[1]), duid[2:]
+	switch {
+	case duidType == 1 && len(data) >= 6:
+		// Hardware type (2 bytes), time (4 bytes), link-layer address
+		return "DUID-LLT " + net.HardwareAddr(data[6:]).String()
+	case duidType == 2 && len(data) >= 4:
+		enterprise := uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+		return fmt.Sprintf("DUID-EN enterprise %d", enterprise)
+	case duidType == 3 && len(data) >= 2:
+		// Hardware type (2 bytes), link-layer address
+		return "DUID-LL " + net.HardwareAddr(data[2:]).String()
+	case duidType == 4 && len(data) == 16:
+		return fmt.Sprintf("DUID-UUID %x-%x-%x-%x-%x", data[0:4], data[4:6], data[6:8], data[8:10], data[10:16])
+	default:
+		return fmt.Sprintf("DUID type %d", duidType)
+	}
+}
+
+// printHosts writes /etc/hosts style lines for the active leases with a known hostname.
+// With a domain the fully qualified name comes first, followed by the short name.
+func printHosts(w io.Writer, leases []LeaseEntry, domain string) error {
+	domain = strings.Trim(domain, ".")
+	now := clock()
+	for _, lease := range leases {
+		if lease.Hostname == "*" || !lease.Active(now) {
+			continue // Unknown or stale names do not belong in a hosts file
+		}
+		names := lease.Hostname
+		if domain != "" {
+			names = lease.Hostname + "." + domain + "\t" + lease.Hostname
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", lease.IPAddress, names); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printDHCPHost writes dnsmasq dhcp-host reservations pinning each lease's address.
+// Leases without a hostname are emitted commented out, to be named before use.
+func printDHCPHost(w io.Writer, leases []LeaseEntry) error {
+	for _, lease := range leases {
+		address := lease.IPAddress
+		if isIPv6(address) {
+			address = "[" + address + "]" // dnsmasq requires brackets around IPv6 addresses
+		}
+		line := fmt.Sprintf("dhcp-host=%s,%s,%s", lease.MACAddress, address, lease.Hostname)
+		if lease.Hostname == "*" {
+			line = fmt.Sprintf("# dhcp-host=%s,%s (no hostname)", lease.MACAddress, address)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printResolvConf writes a "nameserver IP" line for each active lease that is a DNS server:
+// its MAC is listed in --dns-server-mac, or its hostname matches the --hostname pattern.
+// filterLeases leaves that pattern to it, so a server listed by MAC is kept whatever its name.
+func printResolvConf(w io.Writer, leases []LeaseEntry, opts options) error {
+	if opts.DNSServerMACs == "" && opts.Hostname == "" {
+		return fmt.Errorf("--format resolv-conf needs --dns-server-mac LIST or a --hostname pattern to select DNS servers")
+	}
+	var serverMACs []string
+	if opts.DNSServerMACs != "" {
+		serverMACs = strings.Split(opts.DNSServerMACs, ",")
+	}
+	now := clock()
+	count := 0
+	for _, lease := range leases {
+		if !lease.Active(now) {
+			continue
+		}
+		selected := opts.Hostname != "" && hostnameMatches(opts.Hostname, lease.Hostname)
+		for _, mac := range serverMACs {
+			if sameMAC(lease.MACAddress, strings.TrimSpace(mac)) {
+				selected = true
+			}
+		}
+		if !selected {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "nameserver %s\n", lease.IPAddress); err != nil {
+			return err
+		}
+		count++
+	}
+	if count > 3 {
+		slog.Warn("the glibc resolver only uses the first 3 nameservers", "nameservers", count)
+	}
+	return nil
+}
+
+// isIPv6 reports whether the address is an IPv6 address
+func isIPv6(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.To4() == nil
+}
+
+// printIptables writes one iptables ACCEPT rule per active lease.
+// IPv6 leases are emitted as ip6tables rules.
+func printIptables(w io.Writer, leases []LeaseEntry, chain string) error {
+	if chain == "" {
+		chain = "FORWARD"
+	}
+	now := clock()
+	for _, lease := range leases {
+		if !lease.Active(now) {
+			continue // Only active leases belong in the allowlist
+		}
+		command := "iptables"
+		if isIPv6(lease.IPAddress) {
+			command = "ip6tables"
+		}
+		if _, err := fmt.Fprintf(w, "%s -A %s -s %s -j ACCEPT\n", command, chain, lease.IPAddress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printNftables writes one nftables accept rule per active lease
+func printNftables(w io.Writer, leases []LeaseEntry, chain string) error {
+	if chain == "" {
+		chain = "forward"
+	}
+	now := clock()
+	for _, lease := range leases {
+		if !lease.Active(now) {
+			continue // Only active leases belong in the allowlist
+		}
+		family := "ip"
+		if isIPv6(lease.IPAddress) {
+			family = "ip6"
+		}
+		if _, err := fmt.Fprintf(w, "add rule inet filter %s %s saddr %s accept\n", chain, family, lease.IPAddress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printNmapTargets writes the address of every active lease on its own line, the target
+// list format of nmap -iL and masscan -iL. With ports, each address is repeated as one
+// IP:PORT target per port (IPv6 in brackets) for scanners taking such pairs.
+func printNmapTargets(w io.Writer, leases []LeaseEntry, ports []int) error {
+	now := clock()
+	for _, lease := range leases {
+		if !lease.Active(now) {
+			continue // Expired addresses are likely unused or reassigned; scanning them is noise
+		}
+		if len(ports) == 0 {
+			if _, err := fmt.Fprintln(w, lease.IPAddress); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, port := range ports {
+			if _, err := fmt.Fprintln(w, net.JoinHostPort(lease.IPAddress, strconv.Itoa(port))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// printTerraformOutput writes the active leases as the flat JSON object of string values
+// that Terraform's external data source expects, mapping each MAC (or lower-cased
+// hostname) to its IP address. A key holding several addresses, e.g. IPv4 and IPv6,
+// maps to all of them comma-separated in lease order.
+func printTerraformOutput(w io.Writer, leases []LeaseEntry, key string) error {
+	now := clock()
+	var active []LeaseEntry
+	for _, lease := range leases {
+		if lease.Active(now) {
+			active = append(active, lease)
+		}
+	}
+	groups := LeasesByMAC(active)
+	if key == "hostname" {
+		groups = LeasesByHostname(active)
+	}
+	result := make(map[string]string, len(groups))
+	for name, group := range groups {
+		if key == "mac" {
+			name = group[0].MACAddress // As displayed, honoring --mac-case
+		}
+		addresses := make([]string, len(group))
+		for i, lease := range group {
+			addresses[i] = lease.IPAddress
+		}
+		result[name] = strings.Join(addresses, ",")
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+// Record TTL bounds for --format dns-zone
+const (
+	minZoneTTL = 60    // Records never get a shorter TTL, even when the lease is about to expire
+	maxZoneTTL = 86400 // TTL for infinite and very long leases, so caches still pick up changes
+)
+
+// printDNSZone writes a BIND zone file with an A (or AAAA) record per active lease with a
+// hostname. Each record's TTL is the lease's remaining time, clamped to [minZoneTTL, maxZoneTTL].
+func printDNSZone(w io.Writer, leases []LeaseEntry, zone string) error {
+	zone = strings.Trim(zone, ".")
+	if zone == "" {
+		return fmt.Errorf("--format dns-zone needs --zone-name DOMAIN")
+	}
+	now := clock()
+	var b strings.Builder
+	writeZoneHeader(&b, zone, now)
+	for _, lease := range leases {
+		if lease.Hostname == "*" || !lease.Active(now) {
+			continue
+		}
+		fmt.Fprintf(&b, "%s\t%d\tIN\t%s\t%s\n", lease.Hostname, zoneTTL(lease, now), addressRecordType(lease), lease.IPAddress)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeZoneHeader writes the $ORIGIN, $TTL and minimal SOA/NS records of a generated zone
+func writeZoneHeader(b *strings.Builder, zone string, now time.Time) {
+	fmt.Fprintf(b, "$ORIGIN %s.\n", zone)
+	fmt.Fprintf(b, "$TTL %d\n", minZoneTTL)
+	// The serial only has to increase between generations, which the current time does
+	fmt.Fprintf(b, "@\tIN\tSOA\tlocalhost. hostmaster.%s. (%d 3600 600 86400 %d)\n", zone, now.Unix(), minZoneTTL)
+	fmt.Fprintf(b, "@\tIN\tNS\tlocalhost.\n")
+}
+
+// zoneTTL returns the lease's remaining time in seconds, clamped to [minZoneTTL, maxZoneTTL]
+func zoneTTL(lease LeaseEntry, now time.Time) int {
+	if lease.Permanent {
+		return maxZoneTTL
+	}
+	return min(max(int(lease.ExpiryTime.Sub(now).Seconds()), minZoneTTL), maxZoneTTL)
+}
+
+// addressRecordType returns the DNS record type of the lease address, A or AAAA
+func addressRecordType(lease LeaseEntry) string {
+	if isIPv6(lease.IPAddress) {
+		return "AAAA"
+	}
+	return "A"
+}
+
+// printBindRPZ writes a BIND Response Policy Zone with a local-data A (or AAAA) record per
+// active lease with a hostname, so a resolver using the policy zone answers for exactly
+// the devices currently on the network. Owner names are the hostname qualified with
+// domain (if any), relative to the policy zone, as RPZ expects for QNAME triggers.
+func printBindRPZ(w io.Writer, leases []LeaseEntry, zone, domain string) error {
+	zone = strings.Trim(zone, ".")
+	if zone == "" {
+		return fmt.Errorf("--format bind-rpz needs --zone-name POLICY-ZONE")
+	}
+	domain = strings.Trim(domain, ".")
+	now := clock()
+	var b strings.Builder
+	writeZoneHeader(&b, zone, now)
+	for _, lease := range leases {
+		if lease.Hostname == "*" || !lease.Active(now) {
+			continue
+		}
+		owner := lease.Hostname
+		if domain != "" {
+			owner += "." + domain
+		}
+		fmt.Fprintf(&b, "%s\t%d\tIN\t%s\t%s\n", owner, zoneTTL(lease, now), addressRecordType(lease), lease.IPAddress)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// quotedCSVWriter writes CSV rows like csv.Writer, but with every field quoted for
+// --csv-quote-all, which encoding/csv has no option for
+type quotedCSVWriter struct {
+	w     *bufio.Writer
+	comma rune
+	err   error
+}
+
+// Write writes one row, doubling the quotes inside each field
+func (q *quotedCSVWriter) Write(record []string) error {
+	for i, field := range record {
+		if i > 0 {
+			q.w.WriteRune(q.comma)
+		}
+		q.w.WriteByte('"')
+		q.w.WriteString(strings.ReplaceAll(field, `"`, `""`))
+		q.w.WriteByte('"')
+	}
+	_, q.err = q.w.WriteString("\n")
+	return q.err
+}
+
+// Flush writes out the buffered rows, like csv.Writer.Flush
+func (q *quotedCSVWriter) Flush() {
+	q.err = q.w.Flush()
+}
+
+// Error reports any error of a previous Write or Flush, like csv.Writer.Error
+func (q *quotedCSVWriter) Error() error {
+	return q.err
+}
+
+// printCSV writes the table columns as CSV, with a header row unless the format is csv-no-header or --no-header is given
+func printCSV(w io.Writer, leases []LeaseEntry, opts options) error {
+	columns := tableColumns(leases, opts)
+	cells := make([]string, len(columns))
+	var writer interface {
+		Write(record []string) error
+		Flush()
+		Error() error
+	}
+	if opts.CSVQuoteAll {
+		writer = &quotedCSVWriter{w: bufio.NewWriter(w), comma: opts.csvDelimiter}
+	} else {
+		csvWriter := csv.NewWriter(w)
+		csvWriter.Comma = opts.csvDelimiter
+		writer = csvWriter
+	}
+	writeRow := func() error { return writer.Write(cells) }
+	if opts.Format != "csv-no-header" && !opts.NoHeader {
+		for i, column := range columns {
+			cells[i] = column.Header
+		}
+		if err := writeRow(); err != nil {
+			return err
+		}
+	}
+	for _, lease := range leases {
+		for i, column := range columns {
+			cells[i] = column.Value(lease)
+		}
+		if err := writeRow(); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// shellSafe matches values that need no quoting in a shell assignment
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_.:/@%+,-]*$`)
+
+// shellQuote returns the value unchanged if it is safe to eval, otherwise in single quotes
+func shellQuote(value string) string {
+	if value != "" && shellSafe.MatchString(value) {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// printKV writes each lease as a block of key=value lines, separated by blank lines.
+// Values are shell-quoted where necessary so a block can be passed to eval.
+func printKV(w io.Writer, leases []LeaseEntry) error {
+	for i, lease := range leases {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		pairs := [][2]string{
+			{"mac", lease.MACAddress},
+			{"ip", lease.IPAddress},
+			{"hostname", lease.Hostname},
+			{"client_id", lease.ClientID},
+			{"expiry", formatExpiry(lease)},
+		}
+		for _, pair := range pairs {
+			if _, err := fmt.Fprintf(w, "%s=%s\n", pair[0], shellQuote(pair[1])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jinjaString returns the value as a single-quoted Jinja2 string literal. Besides quotes
+// and backslashes, the template delimiter characters { } % # and control characters are
+// written as \xNN escapes, so a value can neither end the literal nor start a tag.
+func jinjaString(value string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range value {
+		switch {
+		case r == '\'' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '{' || r == '}' || r == '%' || r == '#' || r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// printJinja2Vars writes the leases as a Jinja2 list of dicts assigned to "leases",
+// for embedding into Ansible templates. Infinite leases have an expiry of none.
+func printJinja2Vars(w io.Writer, leases []LeaseEntry) error {
+	items := make([]string, len(leases))
+	for i, lease := range leases {
+		expiry := "none"
+		if !lease.Permanent {
+			expiry = jinjaString(lease.ExpiryTime.Format(time.RFC3339))
+		}
+		items[i] = fmt.Sprintf("  {'mac': %s, 'ip': %s, 'hostname': %s, 'client_id': %s, 'expiry': %s, 'permanent': %t}",
+			jinjaString(lease.MACAddress), jinjaString(lease.IPAddress), jinjaString(lease.Hostname),
+			jinjaString(lease.ClientID), expiry, lease.Permanent)
+	}
+	body := ""
+	if len(items) > 0 {
+		body = "\n" + strings.Join(items, ",\n") + "\n"
+	}
+	_, err := fmt.Fprintf(w, "{%%- set leases = [%s] %%}\n", body)
+	return err
+}
+
+// pythonString returns the value as a single-quoted Python string literal,
+// with control characters written as \xNN escapes so the literal stays on one line
+func pythonString(value string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range value {
+		switch {
+		case r == '\'' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// rubyString returns the value as a single-quoted Ruby string literal, which
+// unlike a double-quoted one does not interpolate #{...}
+func rubyString(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+}
+
+// printLiteralList writes the leases as a list of dicts/hashes in the syntax of a scripting language,
+// preceded by assign: str quotes a string, entry formats one key-value pair and null, yes and no are its literals
+func printLiteralList(w io.Writer, leases []LeaseEntry, assign string, str func(string) string, entry, null, yes, no string) error {
+	items := make([]string, len(leases))
+	for i, lease := range leases {
+		expiry, permanent := null, yes
+		if !lease.Permanent {
+			expiry, permanent = str(lease.ExpiryTime.Format(time.RFC3339)), no
+		}
+		pairs := [][2]string{
+			{"mac", str(lease.MACAddress)},
+			{"ip", str(lease.IPAddress)},
+			{"hostname", str(lease.Hostname)},
+			{"client_id", str(lease.ClientID)},
+			{"expiry", expiry},
+			{"permanent", permanent},
+		}
+		fields := make([]string, len(pairs))
+		for j, pair := range pairs {
+			fields[j] = fmt.Sprintf(entry, str(pair[0]), pair[1])
+		}
+		items[i] = "  {" + strings.Join(fields, ", ") + "}"
+	}
+	body := ""
+	if len(items) > 0 {
+		body = "\n" + strings.Join(items, ",\n") + "\n"
+	}
+	_, err := fmt.Fprintf(w, "%s[%s]\n", assign, body)
+	return err
+}
+
+// printPythonList writes the leases as an assignment of a Python list of dicts to leases,
+// so exec() in a Python shell binds it. Infinite leases have an expiry of None.
+func printPythonList(w io.Writer, leases []LeaseEntry) error {
+	return printLiteralList(w, leases, "leases = ", pythonString, "%s: %s", "None", "True", "False")
+}
+
+// printRubyArray writes the leases as a Ruby array literal of hashes, for eval in irb.
+// Infinite leases have an expiry of nil.
+func printRubyArray(w io.Writer, leases []LeaseEntry) error {
+	return printLiteralList(w, leases, "", rubyString, "%s => %s", "nil", "true", "false")
+}
+
+// tomlString returns the value as a TOML basic string, escaping quotes, backslashes
+// and control characters
+func tomlString(value string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// printTOML writes the leases as a TOML array of tables, one [[lease]] per lease.
+// The expiry is a TOML offset date-time; infinite leases have none.
+func printTOML(w io.Writer, leases []LeaseEntry) error {
+	for i, lease := range leases {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "[[lease]]\nmac = %s\nip = %s\nhostname = %s\nclient_id = %s\n",
+			tomlString(lease.MACAddress), tomlString(lease.IPAddress), tomlString(lease.Hostname), tomlString(lease.ClientID))
+		if !lease.Permanent {
+			fmt.Fprintf(w, "expiry = %s\n", lease.ExpiryTime.Format(time.RFC3339))
+		}
+		if _, err := fmt.Fprintf(w, "permanent = %t\n", lease.Permanent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dotString returns the value as a quoted Graphviz DOT string
+func dotString(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+// printGraphviz writes the leases as an undirected DOT graph: a central router node
+// (labeled with routerIP, if given) with one leaf per lease, labeled with its hostname
+// (or MAC when unknown) and IP. Render it with e.g. "dot -Tsvg".
+func printGraphviz(w io.Writer, leases []LeaseEntry, routerIP string) error {
+	router := "router"
+	if routerIP != "" {
+		router += "\n" + routerIP
+	}
+	fmt.Fprintln(w, "graph leases {")
+	fmt.Fprintln(w, "  layout=twopi;")
+	fmt.Fprintln(w, "  node [shape=ellipse, fontsize=10];")
+	fmt.Fprintf(w, "  router [label=%s, shape=box, style=bold];\n", dotString(router))
+	for i, lease := range leases {
+		name := lease.Hostname
+		if name == "*" {
+			name = lease.MACAddress
+		}
+		fmt.Fprintf(w, "  lease%d [label=%s];\n", i, dotString(name+"\n"+lease.IPAddress))
+		fmt.Fprintf(w, "  router -- lease%d;\n", i)
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// ansibleGroup is one group of an Ansible dynamic inventory
+type ansibleGroup struct {
+	Hosts    []string `json:"hosts,omitempty"`
+	Children []string `json:"children,omitempty"`
+}
+
+// leaseSubnet returns the subnet of an address: the first --pool containing it, otherwise
+// its /24 (IPv6: /64). It returns false if the address does not parse.
+func leaseSubnet(address string, pools []netip.Prefix) (netip.Prefix, bool) {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+	for _, pool := range pools {
+		if pool.Contains(addr) {
+			return pool, true
+		}
+	}
+	bits := 24
+	if addr.Is6() {
+		bits = 64
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix, true
+}
+
+// cidrSubnet returns the network of an address in CIDR notation, e.g. 192.168.1.0/24 for
+// 192.168.1.55 and a prefix length of 24; IPv6 addresses always get their /64. An
+// unparseable address yields "-".
+func cidrSubnet(address string, bits int) string {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return "-"
+	}
+	addr = addr.Unmap()
+	if addr.Is6() {
+		bits = 64
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix.String()
+}
+
+// ansibleGroupName returns the inventory group of an address, its leaseSubnet spelled as
+// a valid group name such as subnet_192_168_1_0_24
+func ansibleGroupName(address string, pools []netip.Prefix) string {
+	prefix, ok := leaseSubnet(address, pools)
+	if !ok {
+		return "ungrouped"
+	}
+	return "subnet_" + strings.NewReplacer(".", "_", ":", "_", "/", "_").Replace(prefix.String())
+}
+
+// printAnsibleInventory writes the leases as an Ansible dynamic inventory (the JSON an
+// inventory script prints for --list), grouping hosts by subnet. Hosts without a
+// hostname, and the second address of a dual-stack host, are named by their IP.
+func printAnsibleInventory(w io.Writer, leases []LeaseEntry, poolFlags []string) error {
+	pools, err := parsePools(poolFlags)
+	if err != nil {
+		return err
+	}
+
+	hostvars := map[string]map[string]any{}
+	groups := map[string]*ansibleGroup{}
+	for _, lease := range leases {
+		name := lease.Hostname
+		if _, taken := hostvars[name]; name == "*" || taken {
+			name = lease.IPAddress
+		}
+		var expiry any // null for infinite leases
+		if !lease.Permanent {
+			expiry = lease.ExpiryTime.Format(time.RFC3339)
+		}
+		hostvars[name] = map[string]any{
+			"ansible_host": lease.IPAddress,
+			"mac":          lease.MACAddress,
+			"ip":           lease.IPAddress,
+			"expiry":       expiry,
+		}
+		groupName := ansibleGroupName(lease.IPAddress, pools)
+		if groups[groupName] == nil {
+			groups[groupName] = &ansibleGroup{}
+		}
+		groups[groupName].Hosts = append(groups[groupName].Hosts, name)
+	}
+
+	inventory := map[string]any{"_meta": map[string]any{"hostvars": hostvars}}
+	all := &ansibleGroup{Children: []string{}}
+	for name, group := range groups {
+		inventory[name] = group
+		all.Children = append(all.Children, name)
+	}
+	sort.Strings(all.Children)
+	inventory["all"] = all
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(inventory)
+}
+
+// outputEncodings lists the values accepted by --output-encoding
+var outputEncodings = []string{"utf8", "latin1", "utf16le", "utf16be"}
+
+// newEncodingWriter wraps w so UTF-8 text is written in the given encoding, or returns nil
+// for utf8, which needs no transcoding. Characters latin1 cannot represent become '?', with
+// a single warning. Close writes out a trailing incomplete character.
+func newEncodingWriter(w io.Writer, encoding string) io.WriteCloser {
+	var encoder transform.Transformer
+	switch encoding {
+	case "latin1":
+		warned := false
+		unsupported := runes.Map(func(r rune) rune {
+			if r > 0xff {
+				if !warned {
+					slog.Warn("output contains characters latin1 cannot represent, writing '?'", "char", string(r))
+					warned = true
+				}
+				return '?'
+			}
+			return r
+		})
+		encoder = transform.Chain(unsupported, charmap.ISO8859_1.NewEncoder())
+	case "utf16le":
+		encoder = unicodeenc.UTF16(unicodeenc.LittleEndian, unicodeenc.IgnoreBOM).NewEncoder()
+	case "utf16be":
+		encoder = unicodeenc.UTF16(unicodeenc.BigEndian, unicodeenc.IgnoreBOM).NewEncoder()
+	default:
+		return nil
+	}
+	return transform.NewWriter(w, encoder)
+}
+
+// outputBufferSize is the buffer of --no-flush-per-row, large enough to batch many table rows per write
+const outputBufferSize = 64 << 10
+
+// outputWriter is the destination of listings and reports
+type outputWriter struct {
+	io.Writer
+	enc  io.WriteCloser // nil for utf8
+	buf  *bufio.Writer  // nil unless --no-flush-per-row
+	file *os.File       // nil for standard output
+}
+
+// Close writes out any buffered output and closes the output file, if any
+func (o outputWriter) Close() error {
+	var err error
+	if o.enc != nil {
+		err = o.enc.Close()
+	}
+	if o.buf != nil {
+		err = errors.Join(err, o.buf.Flush())
+	}
+	if o.file != nil {
+		err = errors.Join(err, o.file.Close())
+	}
+	return err
+}
+
+// openOutput returns standard output, or the created --output file, in the given encoding.
+// Rows go out as they are written unless buffered, which saves a write system call per row
+// (or per table cell) on very large listings.
+func openOutput(path, encoding string, buffered bool) (outputWriter, error) {
+	var out outputWriter
+	var w io.Writer = os.Stdout
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return outputWriter{}, fmt.Errorf("creating output file: %w", err)
+		}
+		out.file, w = file, file
+	}
+	if buffered {
+		out.buf = bufio.NewWriterSize(w, outputBufferSize)
+		w = out.buf
+	}
+	out.Writer = w
+	if out.enc = newEncodingWriter(w, encoding); out.enc != nil {
+		out.Writer = out.enc
+	}
+	return out, nil
+}
+
+// render writes the leases to w in the requested output format
+func render(w io.Writer, leases []LeaseEntry, opts options) error {
+	if opts.Format == "table" || strings.HasPrefix(opts.Format, "csv") {
+		// The names are looked up once for all leases, before any row is written
+		if slices.ContainsFunc(tableColumns(leases, opts), func(c tableColumn) bool { return c.Name == "reverse_dns" }) {
+			opts.reverseNames = resolveAddresses(leases, opts.Progress)
+		}
+	}
+	switch opts.Format {
+	case "table":
+		if opts.GroupBy != "" {
+			return printGroupedTable(w, leases, opts)
+		}
+		return printTable(w, leases, opts)
+	case "json":
+		return printJSON(w, leases, opts)
+	case "hosts":
+		return printHosts(w, leases, opts.Domain)
+	case "dhcp-host":
+		return printDHCPHost(w, leases)
+	case "resolv-conf":
+		return printResolvConf(w, leases, opts)
+	case "prometheus":
+		return printPrometheus(w, leaseMetrics(leases, clock(), opts.MetricPrefix, opts.metricLabels))
+	case "iptables":
+		return printIptables(w, leases, opts.Chain)
+	case "nftables":
+		return printNftables(w, leases, opts.Chain)
+	case "ansible":
+		return printAnsibleInventory(w, leases, opts.Pools)
+	case "kv":
+		return printKV(w, leases)
+	case "influx":
+		return printInflux(w, leases, clock())
+	case "dns-zone":
+		return printDNSZone(w, leases, opts.Zone)
+	case "syslog":
+		return printSyslog(leases, opts)
+	case "terraform-output":
+		return printTerraformOutput(w, leases, opts.TerraformKey)
+	case "nmap":
+		return printNmapTargets(w, leases, opts.ports)
+	case "bind-rpz":
+		return printBindRPZ(w, leases, opts.Zone, opts.Domain)
+	case "csv", "csv-no-header":
+		return printCSV(w, leases, opts)
+	case "jinja2-vars":
+		return printJinja2Vars(w, leases)
+	case "python":
+		return printPythonList(w, leases)
+	case "ruby":
+		return printRubyArray(w, leases)
+	case "toml":
+		return printTOML(w, leases)
+	case "graphviz":
+		return printGraphviz(w, leases, opts.RouterIP)
+	default:
+		return fmt.Errorf("unknown output format %q", opts.Format)
+	}
+}
+
+// --- Vendor lookup (--show-vendor, --sort vendor) ---
+
+// builtinVendors maps MAC prefixes (upper-case hex digits, no separators) of
+// common home and lab devices to their manufacturer. It is a small excerpt of
+// the IEEE registry, which has tens of thousands of entries.
+var builtinVendors = map[string]string{
+	"000393": "Apple", "000A95": "Apple", "0017F2": "Apple", "001B63": "Apple", "001EC2": "Apple",
+	"002500": "Apple", "28CFE9": "Apple", "3C0754": "Apple", "ACBC32": "Apple", "F01898": "Apple",
+	"B827EB": "Raspberry Pi", "DCA632": "Raspberry Pi", "E45F01": "Raspberry Pi", "28CDC1": "Raspberry Pi",
+	"D83ADD": "Raspberry Pi", "2CCF67": "Raspberry Pi",
+	"240AC4": "Espressif", "30AEA4": "Espressif", "246F28": "Espressif", "84F3EB": "Espressif",
+	"A4CF12": "Espressif", "5CCF7F": "Espressif", "600194": "Espressif", "ECFABC": "Espressif", "18FE34": "Espressif",
+	"005056": "VMware", "000C29": "VMware", "000569": "VMware", "001C14": "VMware",
+	"525400": "QEMU/KVM", "080027": "VirtualBox", "00163E": "Xen", "00155D": "Microsoft Hyper-V", "0242AC": "Docker",
+	"0050F2": "Microsoft", "281878": "Microsoft", "7C1E52": "Microsoft",
+	"3C5AB4": "Google", "F4F5D8": "Google", "546009": "Google",
+	"44650D": "Amazon", "74C246": "Amazon", "F0272D": "Amazon", "FC65DE": "Amazon",
+	"000E58": "Sonos", "5CAAFD": "Sonos", "949F3E": "Sonos", "B8E937": "Sonos", "78288C": "Sonos",
+	"00156D": "Ubiquiti", "002722": "Ubiquiti", "0418D6": "Ubiquiti", "24A43C": "Ubiquiti", "44D9E7": "Ubiquiti",
+	"687251": "Ubiquiti", "802AA8": "Ubiquiti", "F09FC2": "Ubiquiti", "DC9FDB": "Ubiquiti", "788A20": "Ubiquiti",
+	"FCECDA": "Ubiquiti",
+	"50C7BF": "TP-Link", "14CC20": "TP-Link", "C04A00": "TP-Link", "98DED0": "TP-Link", "EC086B": "TP-Link",
+	"F4F26D": "TP-Link", "60E327": "TP-Link",
+	"00095B": "Netgear", "000FB5": "Netgear", "00146C": "Netgear", "00184D": "Netgear", "001B2F": "Netgear",
+	"001E2A": "Netgear", "00223F": "Netgear", "0024B2": "Netgear",
+	"001132": "Synology",
+	"0009BF": "Nintendo", "0017AB": "Nintendo", "00191D": "Nintendo", "001F32": "Nintendo", "00224C": "Nintendo",
+	"98B6E9": "Nintendo",
+	"00D9D1": "Sony Interactive", "280DFC": "Sony Interactive", "709E29": "Sony Interactive", "BC60A7": "Sony Interactive",
+	"0000F0": "Samsung", "001247": "Samsung", "001599": "Samsung", "001632": "Samsung", "002119": "Samsung",
+	"002339": "Samsung",
+	"001422": "Dell", "001E4F": "Dell", "002170": "Dell", "0024E8": "Dell", "F8B156": "Dell", "B8CA3A": "Dell",
+	"D4BED9": "Dell",
+	"0001E6": "HP", "0002A5": "HP", "000BCD": "HP", "000E7F": "HP", "001185": "HP", "001321": "HP", "001438": "HP",
+	"001560": "HP", "001708": "HP", "0018FE": "HP", "001A4B": "HP", "001B78": "HP", "001CC4": "HP", "00215A": "HP",
+	"001882": "Huawei", "001E10": "Huawei", "00259E": "Huawei", "00E0FC": "Huawei", "286ED4": "Huawei",
+	"009EC8": "Xiaomi", "286C07": "Xiaomi", "34CE00": "Xiaomi", "640980": "Xiaomi", "7811DC": "Xiaomi", "F8A45F": "Xiaomi",
+	"00E04C": "Realtek", "001018": "Broadcom", "00000C": "Cisco",
+	"00040E": "AVM", "00150C": "AVM", "001C4A": "AVM", "001F3F": "AVM", "0024FE": "AVM", "246511": "AVM",
+	"2C91AB": "AVM", "3481C4": "AVM", "3810D5": "AVM", "3CA62F": "AVM", "444E6D": "AVM", "989BCB": "AVM",
+	"BC0543": "AVM", "C80E14": "AVM", "DC396F": "AVM", "E0286D": "AVM",
+	"000C6E": "ASUSTek", "000EA6": "ASUSTek", "00112F": "ASUSTek", "0011D8": "ASUSTek", "0013D4": "ASUSTek",
+	"0015F2": "ASUSTek", "001731": "ASUSTek", "0018F3": "ASUSTek", "001A92": "ASUSTek", "001BFC": "ASUSTek",
+	"B0A737": "Roku", "DC3A5E": "Roku", "CC6DA0": "Roku",
+	"001788": "Philips Hue", "ECB5FA": "Philips Hue", "A8610A": "Arduino",
+	"00044B": "NVIDIA", "48B02D": "NVIDIA",
+	"002590": "Super Micro", "0CC47A": "Super Micro", "AC1F6B": "Super Micro",
+	"000C42": "MikroTik", "4C5E0C": "MikroTik", "64D154": "MikroTik", "6C3B6B": "MikroTik", "B869F4": "MikroTik",
+	"CC2DE0": "MikroTik", "D4CA6D": "MikroTik", "E48D8C": "MikroTik", "2CC81B": "MikroTik", "488F5A": "MikroTik",
+	"744D28": "MikroTik", "DC2C6E": "MikroTik",
+}
+
+// vendorDB is the vendor table consulted by lookupVendor
+var vendorDB = builtinVendors
+
+// ieeeRegistries are the IEEE MA-L (24-bit), MA-M (28-bit) and MA-S (36-bit) assignment
+// lists fetched by update-vendor-db
+var ieeeRegistries = []string{
+	"https://standards-oui.ieee.org/oui/oui.csv",
+	"https://standards-oui.ieee.org/oui28/mam.csv",
+	"https://standards-oui.ieee.org/oui36/oui36.csv",
+}
+
+// vendorDownloadTimeout bounds the download of one registry; oui.csv is several megabytes
+const vendorDownloadTimeout = 2 * time.Minute
+
+// readVendorCSV adds the assignments of an IEEE registry CSV (Registry,Assignment,
+// Organization Name,Organization Address) to db, keyed like builtinVendors by the
+// upper-case hex prefix. Header rows, which may repeat in concatenated files, are
+// skipped. It returns the number of assignments read.
+func readVendorCSV(r io.Reader, db map[string]string) (int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // The address column is sometimes missing
+	reader.LazyQuotes = true
+	count := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if len(record) < 3 || indexOf([]string{"MA-L", "MA-M", "MA-S"}, record[0]) < 0 {
+			continue // Header or blank row
+		}
+		prefix := strings.ToUpper(strings.TrimSpace(record[1]))
+		if strings.Trim(prefix, "0123456789ABCDEF") != "" || (len(prefix) != 6 && len(prefix) != 7 && len(prefix) != 9) {
+			line, _ := reader.FieldPos(1)
+			return count, fmt.Errorf("line %d: invalid assignment %q", line, record[1])
+		}
+		db[prefix] = strings.TrimSpace(record[2])
+		count++
+	}
+}
+
+// loadVendorFile reads a --vendor-file into a vendor table for lookupVendor
+func loadVendorFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening vendor file: %w", err)
+	}
+	defer file.Close()
+	db := make(map[string]string)
+	count, err := readVendorCSV(file, db)
+	if err != nil {
+		return nil, fmt.Errorf("error reading vendor file %s: %w", path, err)
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("vendor file %s has no MA-L, MA-M or MA-S assignments", path)
+	}
+	slog.Debug("loaded vendor file", "file", path, "prefixes", count)
+	return db, nil
+}
+
+// updateVendorFile downloads the IEEE registries and writes them, concatenated, to path.
+// Each download is checked to parse before anything is written, so a failed update keeps
+// the previous file. The built-in table is compiled in and cannot be replaced at run
+// time; point --vendor-file at the result instead.
+func updateVendorFile(path string) (int, error) {
+	client := &http.Client{Timeout: vendorDownloadTimeout}
+	var data bytes.Buffer
+	total := 0
+	for _, url := range ieeeRegistries {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("User-Agent", programName) // The IEEE server rejects some default agents
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, fmt.Errorf("downloading %s: %w", url, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return 0, fmt.Errorf("downloading %s: %w", url, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("downloading %s: %s", url, resp.Status)
+		}
+		count, err := readVendorCSV(bytes.NewReader(body), map[string]string{})
+		if err != nil {
+			return 0, fmt.Errorf("parsing %s: %w", url, err)
+		}
+		slog.Debug("downloaded registry", "url", url, "prefixes", count)
+		total += count
+		data.Write(body)
+		if len(body) > 0 && body[len(body)-1] != '\n' {
+			data.WriteByte('\n')
+		}
+	}
+	if err := writeFileAtomic(path, data.Bytes()); err != nil {
+		return 0, fmt.Errorf("error writing vendor file: %w", err)
+	}
+	return total, nil
+}
+
+// lookupVendor returns the manufacturer registered for the MAC's prefix, or "" if it is unknown.
+// The longer MA-S (36-bit) and MA-M (28-bit) blocks take precedence over the 24-bit OUI.
+func lookupVendor(mac string) string {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) < 6 {
+		return ""
+	}
+	digits := strings.ToUpper(hex.EncodeToString(hw[:6]))
+	for _, n := range []int{9, 7, 6} {
+		if vendor, ok := vendorDB[digits[:n]]; ok {
+			return vendor
+		}
+	}
+	return ""
+}
+
+// isRandomMAC reports whether the MAC has the locally administered bit set in its first
+// octet, as the privacy-randomized addresses of modern phones and laptops do. Such
+// devices may show up with a different MAC after reconnecting.
+func isRandomMAC(mac string) bool {
+	hw, err := net.ParseMAC(mac)
+	return err == nil && hw[0]&0x02 != 0
+}
+
+// vendorOrUnknown is lookupVendor for display
+func vendorOrUnknown(mac string) string {
+	if vendor := lookupVendor(mac); vendor != "" {
+		return vendor
+	}
+	return "Unknown"
+}
+
+// --- Lookups (--mac-to-ip, ...) ---
+
+// macCases are the accepted --mac-case styles
+var macCases = []string{"lower", "upper"}
+
+// NormalizeMAC returns a MAC address in colon notation in the given case style, "lower"
+// (as net.HardwareAddr.String writes it) or "upper"; a MAC that does not parse only has
+// its case changed
+func NormalizeMAC(mac, style string) string {
+	if hw, err := net.ParseMAC(mac); err == nil {
+		mac = hw.String()
+	}
+	if style == "upper" {
+		return strings.ToUpper(mac)
+	}
+	return strings.ToLower(mac)
+}
+
+// sameMAC compares two MAC addresses regardless of case and separator style
+func sameMAC(a, b string) bool {
+	hwA, errA := net.ParseMAC(a)
+	hwB, errB := net.ParseMAC(b)
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+	return bytes.Equal(hwA, hwB)
+}
+
+// countLeases counts the leases selected by the count sub-command's arguments:
+// active, expired, total, or expiring-soon DURATION (active but expiring within DURATION).
+// Permanent leases are active and never expiring soon.
+func countLeases(leases []LeaseEntry, args []string, now time.Time) int {
+	var within time.Duration
+	if args[0] == "expiring-soon" {
+		within, _ = time.ParseDuration(args[1]) // Validated by parseFlags
+	}
+	count := 0
+	for _, lease := range leases {
+		switch args[0] {
+		case "active":
+			if lease.Active(now) {
+				count++
+			}
+		case "expired":
+			if !lease.Active(now) {
+				count++
+			}
+		case "expiring-soon":
+			if lease.Active(now) && !lease.Permanent && lease.ExpiryTime.Sub(now) <= within {
+				count++
+			}
+		default:
+			count++
+		}
+	}
+	return count
+}
+
+// printStats writes summary statistics of the leases as labeled lines
+func printStats(w io.Writer, leases []LeaseEntry, now time.Time) error {
+	var active, expired, permanent, ipv4, ipv6 int
+	var next, last time.Time
+	macs, ips := map[string]bool{}, map[string]bool{}
+	for _, lease := range leases {
+		switch {
+		case lease.Permanent:
+			permanent++
+			active++
+		case lease.Active(now):
+			active++
+			if next.IsZero() || lease.ExpiryTime.Before(next) {
+				next = lease.ExpiryTime
+			}
+			if lease.ExpiryTime.After(last) {
+				last = lease.ExpiryTime
+			}
+		default:
+			expired++
+		}
+		if addr, err := netip.ParseAddr(lease.IPAddress); err == nil && addr.Unmap().Is4() {
+			ipv4++
+		} else if err == nil {
+			ipv6++
+		}
+		macs[strings.ToLower(lease.MACAddress)] = true
+		ips[lease.IPAddress] = true
+	}
+	writer := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(writer, "Leases:\t%d\n", len(leases))
+	fmt.Fprintf(writer, "Active:\t%d\n", active)
+	fmt.Fprintf(writer, "Expired:\t%d\n", expired)
+	fmt.Fprintf(writer, "Permanent:\t%d\n", permanent)
+	fmt.Fprintf(writer, "IPv4 / IPv6:\t%d / %d\n", ipv4, ipv6)
+	fmt.Fprintf(writer, "Unique MACs:\t%d\n", len(macs))
+	fmt.Fprintf(writer, "Unique IPs:\t%d\n", len(ips))
+	if !next.IsZero() {
+		fmt.Fprintf(writer, "Next Expiry:\t%s (in %s)\n", next.Format("2006-01-02 15:04:05"), FormatDuration(next.Sub(now)))
+		fmt.Fprintf(writer, "Last Expiry:\t%s (in %s)\n", last.Format("2006-01-02 15:04:05"), FormatDuration(last.Sub(now)))
+	}
+	return writer.Flush()
+}
+
+// validateLeaseFiles parses every file and reports its lease count and malformed lines,
+// returning false if any line is malformed. An unreadable file is an error.
+func validateLeaseFiles(w io.Writer, paths []string, opts options) (bool, error) {
+	valid := true
+	for _, path := range paths {
+		leases, skipped, _, err := readLeaseFile(path, opts)
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(w, "%s: %d leases, %d malformed lines\n", path, len(leases), len(skipped))
+		for _, s := range skipped {
+			fmt.Fprintf(w, "%s:%d: %v\n", path, s.Line, s.Reason)
+		}
+		if len(skipped) > 0 {
+			valid = false
+		}
+	}
+	return valid, nil
+}
+
+// showLease prints every field of the lease(s) held by the MAC as "Label: value" lines,
+// one block per lease; an unknown MAC is reported as an error
+func showLease(w io.Writer, leases []LeaseEntry, mac string, now time.Time) (bool, error) {
+	if _, err := net.ParseMAC(mac); err != nil {
+		return false, fmt.Errorf("invalid MAC address %q: %w", mac, err)
+	}
+	found, err := printLeaseDetails(w, leases, func(lease LeaseEntry) bool { return sameMAC(lease.MACAddress, mac) }, now, false, false)
+	if err == nil && !found {
+		err = fmt.Errorf("no lease found for MAC %s", mac)
+	}
+	return found, err
+}
+
+// lookupLease prints every field of the lease(s) holding an IP address or MAC address,
+// for incident response; with resolve the reverse DNS names of the address are added
+func lookupLease(w io.Writer, leases []LeaseEntry, query string, now time.Time, resolve, showProgress bool) (bool, error) {
+	var match func(LeaseEntry) bool
+	if _, err := netip.ParseAddr(query); err == nil {
+		match = func(lease LeaseEntry) bool { return sameIP(lease.IPAddress, query) }
+	} else if _, err := net.ParseMAC(query); err == nil {
+		match = func(lease LeaseEntry) bool { return sameMAC(lease.MACAddress, query) }
+	} else {
+		return false, fmt.Errorf("%q is neither an IP nor a MAC address", query)
+	}
+	found, err := printLeaseDetails(w, leases, match, now, resolve, showProgress)
+	if err == nil && !found {
+		err = fmt.Errorf("no lease found for %s", query)
+	}
+	return found, err
+}
+
+// printLeaseDetails writes every matching lease as a block of labeled lines and reports whether any matched.
+// showProgress reports the progress of the reverse DNS lookups on stderr.
+func printLeaseDetails(w io.Writer, leases []LeaseEntry, match func(LeaseEntry) bool, now time.Time, resolve, showProgress bool) (bool, error) {
+	var bar *progress
+	if resolve {
+		matches := 0
+		for _, lease := range leases {
+			if match(lease) {
+				matches++
+			}
+		}
+		bar = newProgress(showProgress, "Resolving", matches)
+	}
+	writer := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	found := false
+	for _, lease := range leases {
+		if !match(lease) {
+			continue
+		}
+		if found {
+			fmt.Fprintln(writer) // Blank line between the leases of a dual-stack device
+		}
+		fmt.Fprintf(writer, "MAC Address:\t%s\n", lease.MACAddress)
+		fmt.Fprintf(writer, "IP Address:\t%s\n", lease.IPAddress)
+		fmt.Fprintf(writer, "Hostname:\t%s\n", lease.Hostname)
+		fmt.Fprintf(writer, "Client ID:\t%s\n", lease.ClientID)
+		if lease.ClientID != "*" {
+			fmt.Fprintf(writer, "Client ID Type:\t%s\n", decodeClientID(lease.ClientID))
+		}
+		fmt.Fprintf(writer, "Vendor:\t%s\n", vendorOrUnknown(lease.MACAddress))
+		fmt.Fprintf(writer, "Expiry Time:\t%s\n", formatExpiry(lease))
+		fmt.Fprintf(writer, "Remaining:\t%s\n", formatRemaining(lease, now))
+		if !lease.StartTime.IsZero() {
+			fmt.Fprintf(writer, "Start Time:\t%s\n", lease.StartTime.Format("2006-01-02 15:04:05"))
+		}
+		if len(lease.Tags) > 0 {
+			fmt.Fprintf(writer, "Tags:\t%s\n", lease.Tags)
+		}
+		if lease.Source != "" {
+			fmt.Fprintf(writer, "Source:\t%s\n", lease.Source)
+		}
+		if resolve {
+			fmt.Fprintf(writer, "Reverse DNS:\t%s\n", reverseDNS(lease.IPAddress))
+			bar.Step()
+		}
+		found = true
+	}
+	bar.Done() // Before the details appear on the terminal
+	return found, writer.Flush()
+}
+
+// resolveAddresses looks up the reverse DNS names of every distinct address of the leases,
+// one after the other, drawing a progress bar on stderr if showProgress is set
+func resolveAddresses(leases []LeaseEntry, showProgress bool) map[string]string {
+	names := make(map[string]string, len(leases))
+	for _, lease := range leases {
+		names[lease.IPAddress] = ""
+	}
+	bar := newProgress(showProgress, "Resolving", len(names))
+	for ip := range names {
+		names[ip] = reverseDNS(ip)
+		bar.Step()
+	}
+	bar.Done() // Before the table appears on the terminal
+	return names
+}
+
+// reverseDNS returns the PTR names of an address, or why there are none
+func reverseDNS(ip string) string {
+	names, err := net.LookupAddr(ip)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return "(none)"
+		}
+		return "(lookup failed: " + err.Error() + ")"
+	}
+	if len(names) == 0 {
+		return "(none)"
+	}
+	return strings.Join(names, ", ")
+}
+
+// waitPollInterval is how often the wait sub-command re-reads the lease files
+const waitPollInterval = time.Second
+
+// waitForLease polls the leases until the MAC holds an active lease, then prints its IP
+// address(es). It reports false once the timeout has passed without one. Read errors
+// are only warned about once, since the file may be missing until the first lease is handed out.
+func waitForLease(w io.Writer, load func() ([]LeaseEntry, error), mac string, timeout time.Duration) (bool, error) {
+	deadline := clock().Add(timeout)
+	lastErr := ""
+	for {
+		leases, err := load()
+		if err != nil && err.Error() != lastErr {
+			slog.Warn("reading leases failed", "error", err)
+			lastErr = err.Error()
+		}
+		now := clock()
+		found := false
+		for _, lease := range leases {
+			if sameMAC(lease.MACAddress, mac) && lease.Active(now) {
+				fmt.Fprintln(w, lease.IPAddress)
+				found = true
+			}
+		}
+		if found {
+			return true, nil
+		}
+		if !now.Before(deadline) {
+			fmt.Fprintf(os.Stderr, "no active lease for %s after %v\n", mac, timeout)
+			return false, nil
+		}
+		time.Sleep(min(waitPollInterval, deadline.Sub(now)))
+	}
+}
+
+// lookupMACToIP prints the IP address of every lease held by the MAC and reports whether any was found
+func lookupMACToIP(w io.Writer, leases []LeaseEntry, mac string) (bool, error) {
+	if _, err := net.ParseMAC(mac); err != nil {
+		return false, fmt.Errorf("invalid MAC address %q: %w", mac, err)
+	}
+	found := false
+	for _, lease := range leases {
+		if sameMAC(lease.MACAddress, mac) {
+			fmt.Fprintln(w, lease.IPAddress)
+			found = true
+		}
+	}
+	return found, nil
+}
+
+// sameIP compares two IP addresses in their canonical form
+func sameIP(a, b string) bool {
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return addrA.Unmap() == addrB.Unmap()
+}
+
+// lookupIPToMAC prints the MAC address (and, if requested, the hostname) of every lease holding the IP
+func lookupIPToMAC(w io.Writer, leases []LeaseEntry, ip string, withHostname bool) (bool, error) {
+	if _, err := netip.ParseAddr(ip); err != nil {
+		return false, fmt.Errorf("invalid IP address %q: %w", ip, err)
+	}
+	found := false
+	for _, lease := range leases {
+		if !sameIP(lease.IPAddress, ip) {
+			continue
+		}
+		if withHostname {
+			fmt.Fprintf(w, "%s %s\n", lease.MACAddress, lease.Hostname)
+		} else {
+			fmt.Fprintln(w, lease.MACAddress)
+		}
+		found = true
+	}
+	return found, nil
+}
+
+// lookupIPToHostname prints the hostname ("*" when unknown) of every lease holding the IP
+func lookupIPToHostname(w io.Writer, leases []LeaseEntry, ip string) (bool, error) {
+	if _, err := netip.ParseAddr(ip); err != nil {
+		return false, fmt.Errorf("invalid IP address %q: %w", ip, err)
+	}
+	found := false
+	for _, lease := range leases {
+		if sameIP(lease.IPAddress, ip) {
+			fmt.Fprintln(w, lease.Hostname)
+			found = true
+		}
+	}
+	return found, nil
+}
+
+// lookupHostnameToIP prints every address leased under the hostname (case-insensitive),
+// e.g. both the IPv4 and IPv6 lease of a dual-stack device
+func lookupHostnameToIP(w io.Writer, leases []LeaseEntry, hostname string) (bool, error) {
+	found := false
+	for _, lease := range leases {
+		if strings.EqualFold(lease.Hostname, hostname) {
+			fmt.Fprintln(w, lease.IPAddress)
+			found = true
+		}
+	}
+	if !found {
+		fmt.Fprintf(os.Stderr, "hostname %q not found\n", hostname)
+	}
+	return found, nil
+}
+
+// lookupStatus returns the exit status of a lookup: 0 when it found something and 1 otherwise
+func lookupStatus(found bool, err error) int {
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if !found {
+		return 1
+	}
+	return 0
+}
+
+// --- dnsmasq log correlation (--log) ---
+
+// dhcpAckPattern matches dnsmasq DHCPACK log lines, with or without a transaction ID:
+//
+//	dnsmasq-dhcp[812]: DHCPACK(br0) 192.168.1.5 aa:bb:cc:dd:ee:ff laptop
+//	dnsmasq-dhcp[812]: 3204187 DHCPACK(br0) 192.168.1.5 aa:bb:cc:dd:ee:ff laptop
+var dhcpAckPattern = regexp.MustCompile(`dnsmasq(?:-dhcp)?\[\d+\]: (?:\d+ )?DHCPACK\([^)]*\) (\S+) ([0-9A-Fa-f:]+)`)
+
+// parseLogTime reads the timestamp at the start of a syslog line, either RFC 3339
+// ("2024-10-15T09:00:01+02:00 host ...") or BSD style ("Oct 15 09:00:01 host ...").
+// BSD timestamps carry no year, so the most recent matching date not after now is used.
+func parseLogTime(line string, now time.Time) (time.Time, bool) {
+	if first, _, ok := strings.Cut(line, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, first); err == nil {
+			return t, true
+		}
+	}
+	if len(line) < 15 {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation("Jan _2 15:04:05", line[:15], time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0) // A December line read in January
+	}
+	return t, true
+}
+
+// readDHCPAcks returns the time of the last DHCPACK per "mac ip" pair found in the log file
+// and its rotated predecessor (FILE.1). Missing or unreadable files are skipped, so a log
+// that was just rotated away only loses history instead of failing the run.
+func readDHCPAcks(logPath string, now time.Time) map[string]time.Time {
+	acks := make(map[string]time.Time)
+	for _, path := range []string{logPath + ".1", logPath} {
+		file, err := os.Open(path)
+		if err != nil {
+			if path == logPath {
+				slog.Warn("cannot read the dnsmasq log", "error", err)
+			}
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := scanner.Text()
+			match := dhcpAckPattern.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			at, ok := parseLogTime(line, now)
+			if !ok {
+				continue
+			}
+			key := strings.ToLower(match[2]) + " " + match[1]
+			if at.After(acks[key]) {
+				acks[key] = at
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			slog.Warn("reading the dnsmasq log failed", "file", path, "error", err) // Keep what was read so far
+		}
+		file.Close()
+	}
+	return acks
+}
+
+// attachLogStarts sets StartTime on every lease that has a matching DHCPACK in the log
+func attachLogStarts(leases []LeaseEntry, logPath string) {
+	acks := readDHCPAcks(logPath, clock())
+	for i := range leases {
+		if at, ok := acks[leaseKey(leases[i])]; ok {
+			leases[i].StartTime = at
+		}
+	}
+}
+
+// --- Sorting (--sort) ---
+
+// sortKeys are the values accepted by --sort; the first five are in the same order as tuiColumns
+var sortKeys = []string{"expiry", "mac", "ip", "hostname", "client-id", "vendor"}
+
+// compareIP orders IP addresses numerically, falling back to string order for unparsable values
+func compareIP(a, b string) int {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return strings.Compare(a, b)
+	}
+	return bytes.Compare(ipA.To16(), ipB.To16())
+}
+
+// compareLeases orders two leases by one of the sortKeys; a non-nil collator is used for hostnames
+func compareLeases(a, b LeaseEntry, key string, collator *collate.Collator) int {
+	switch key {
+	case "expiry":
+		// Permanent leases never expire, so they sort after every dated lease
+		if a.Permanent != b.Permanent {
+			if a.Permanent {
+				return 1
+			}
+			return -1
+		}
+		return a.ExpiryTime.Compare(b.ExpiryTime)
+	case "mac":
+		return strings.Compare(strings.ToLower(a.MACAddress), strings.ToLower(b.MACAddress))
+	case "ip":
+		return compareIP(a.IPAddress, b.IPAddress)
+	case "hostname":
+		if collator != nil {
+			return collator.CompareString(a.Hostname, b.Hostname)
+		}
+		return strings.Compare(strings.ToLower(a.Hostname), strings.ToLower(b.Hostname))
+	case "vendor":
+		// Devices of unknown make sort after every known vendor
+		vendorA, vendorB := lookupVendor(a.MACAddress), lookupVendor(b.MACAddress)
+		if (vendorA == "") != (vendorB == "") {
+			if vendorA == "" {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(strings.ToLower(vendorA), strings.ToLower(vendorB))
+	default:
+		return strings.Compare(a.ClientID, b.ClientID)
+	}
+}
+
+// SortField selects the lease field SortLeases orders by
+type SortField int
+
+// Sort fields, in the same order as sortKeys (and, up to SortByClientID, tuiColumns)
+const (
+	SortByExpiry   SortField = iota // Expiry time, permanent leases last
+	SortByMAC                       // MAC address, case-insensitive
+	SortByIP                        // IP address, numerically
+	SortByHostname                  // Hostname, case-insensitive
+	SortByClientID                  // Client identifier
+	SortByVendor                    // MAC vendor, unknown vendors last
+)
+
+// SortLeases returns a sorted copy of the leases, leaving the caller's slice untouched.
+// The sort is stable, so leases with equal keys keep their relative order.
+// A SortField that is not one of the constants above sorts by expiry, the default.
+func SortLeases(leases []LeaseEntry, by SortField, descending bool) []LeaseEntry {
+	if by < 0 || int(by) >= len(sortKeys) {
+		by = SortByExpiry
+	}
+	sorted := slices.Clone(leases)
+	key := sortKeys[by]
+	sort.SliceStable(sorted, func(i, j int) bool {
+		c := compareLeases(sorted[i], sorted[j], key, nil)
+		if descending {
+			return c > 0
+		}
+		return c < 0
+	})
+	return sorted
+}
+
+// sortLeases sorts the leases in place by the comma-separated --sort keys (later keys break ties),
+// honoring --reverse and --hostname-sort-locale
+func sortLeases(leases []LeaseEntry, opts options) {
+	if opts.Sort == "" {
+		return // Keep file order
+	}
+	keys := strings.Split(opts.Sort, ",")
+	sort.SliceStable(leases, func(i, j int) bool {
+		var c int
+		for _, key := range keys {
+			if c = compareLeases(leases[i], leases[j], key, opts.collator); c != 0 {
+				break
+			}
+		}
+		if opts.Reverse {
+			return c > 0
+		}
+		return c < 0
+	})
+}
+
+// indexOf returns the position of value in list, or -1
+func indexOf(list []string, value string) int {
+	for i, v := range list {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// --- Reservation reconciliation (--reconcile) ---
+
+// reconcileTimeout bounds the request to the reservations API
+const reconcileTimeout = 30 * time.Second
+
+// reservation is one expected device as returned by the reservations API:
+//
+//	[{"mac": "aa:bb:cc:dd:ee:ff", "ip": "192.168.1.5", "hostname": "laptop"}, ...]
+//
+// Only the MAC is required.
+type reservation struct {
+	MAC      string `json:"mac"`
+	IP       string `json:"ip,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// reconciliation is the three-way comparison of active leases and reservations
+type reconciliation struct {
+	Matched    []reconciledLease `json:"matched"`    // Active leases of reserved MACs
+	Unexpected []LeaseEntry      `json:"unexpected"` // Active leases of MACs without a reservation
+	Missing    []reservation     `json:"missing"`    // Reservations without an active lease
+}
+
+// reconciledLease pairs an active lease with its reservation
+type reconciledLease struct {
+	Lease       LeaseEntry  `json:"lease"`
+	Reservation reservation `json:"reservation"`
+	IPMismatch  bool        `json:"ip_mismatch"` // The reservation names a different IP address
+}
+
+// fetchReservations GETs and decodes the reservation list
+func fetchReservations(url string) ([]reservation, error) {
+	client := &http.Client{Timeout: reconcileTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("fetching reservations: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reservations API %s returned %s", url, resp.Status)
+	}
+	var reservations []reservation
+	if err := json.NewDecoder(resp.Body).Decode(&reservations); err != nil {
+		return nil, fmt.Errorf("decoding reservations from %s: %w", url, err)
+	}
+	for i, r := range reservations {
+		if _, err := net.ParseMAC(r.MAC); err != nil {
+			return nil, fmt.Errorf("reservation %d: invalid MAC address %q", i+1, r.MAC)
+		}
+	}
+	return reservations, nil
+}
+
+// reconcileLeases matches the active leases to the reservations by MAC address
+func reconcileLeases(leases []LeaseEntry, reservations []reservation, now time.Time) reconciliation {
+	report := reconciliation{Matched: []reconciledLease{}, Unexpected: []LeaseEntry{}, Missing: []reservation{}}
+	leased := make([]bool, len(reservations))
+	for _, lease := range leases {
+		if !lease.Active(now) {
+			continue
+		}
+		matched := false
+		for i, r := range reservations {
+			if !sameMAC(lease.MACAddress, r.MAC) {
+				continue
+			}
+			mismatch := r.IP != "" && !sameIP(lease.IPAddress, r.IP)
+			report.Matched = append(report.Matched, reconciledLease{Lease: lease, Reservation: r, IPMismatch: mismatch})
+			leased[i] = true
+			matched = true
+			break
+		}
+		if !matched {
+			report.Unexpected = append(report.Unexpected, lease)
+		}
+	}
+	for i, r := range reservations {
+		if !leased[i] {
+			report.Missing = append(report.Missing, r)
+		}
+	}
+	return report
+}
+
+// printReconciliation writes the report as a table with a Status column, or as JSON
+func printReconciliation(w io.Writer, report reconciliation, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	writer := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "Status\tMAC Address\tIP Address\tHostname\tNote")
+	fmt.Fprintln(writer, "------\t-----------\t----------\t--------\t----")
+	for _, m := range report.Matched {
+		note := ""
+		if m.IPMismatch {
+			note = "reserved " + m.Reservation.IP
+		}
+		fmt.Fprintf(writer, "matched\t%s\t%s\t%s\t%s\n", m.Lease.MACAddress, m.Lease.IPAddress, m.Lease.Hostname, note)
+	}
+	for _, lease := range report.Unexpected {
+		fmt.Fprintf(writer, "unexpected\t%s\t%s\t%s\tno reservation\n", lease.MACAddress, lease.IPAddress, lease.Hostname)
+	}
+	for _, r := range report.Missing {
+		fmt.Fprintf(writer, "missing\t%s\t%s\t%s\tno active lease\n", r.MAC, r.IP, r.Hostname)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	slog.Info("reconciled leases", "matched", len(report.Matched), "unexpected", len(report.Unexpected), "missing", len(report.Missing))
+	return nil
+}
+
+// --- ARP cross-check (--arp) ---
+
+// arpTablePath is the kernel ARP table on Linux
+const arpTablePath = "/proc/net/arp"
+
+// arpEntry is one neighbor from the ARP table
+type arpEntry struct {
+	IP     string
+	MAC    string
+	Device string
+}
+
+// readARPTable parses /proc/net/arp:
+//
+//	IP address       HW type     Flags       HW address            Mask     Device
+//	192.168.1.5      0x1         0x2         aa:bb:cc:dd:ee:ff     *        eth0
+//
+// Incomplete entries (flags 0x0, still being resolved) are skipped.
+func readARPTable(path string) ([]arpEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading ARP table: %w", err)
+	}
+	var entries []arpEntry
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if i == 0 || len(fields) < 6 || fields[2] == "0x0" {
+			continue // Header, blank line or incomplete entry
+		}
+		entries = append(entries, arpEntry{IP: fields[0], MAC: fields[3], Device: fields[5]})
+	}
+	return entries, nil
+}
+
+// arpDiscrepancy is one disagreement between the leases and the ARP table
+type arpDiscrepancy struct {
+	Status   string `json:"status"` // ip-conflict, mac-moved or no-lease
+	MAC      string `json:"mac"`
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname,omitempty"`
+	Note     string `json:"note"`
+}
+
+// compareARP cross-references the active leases with the ARP table: a lease whose IP answers
+// from another MAC (ip-conflict), a lease whose MAC is seen at other addresses only (mac-moved),
+// and a neighbor neither of whose MAC and IP is leased (no-lease). ARP knows only IPv4,
+// so IPv6 leases are not checked. showProgress reports the leases checked on stderr.
+func compareARP(leases []LeaseEntry, entries []arpEntry, now time.Time, showProgress bool) []arpDiscrepancy {
+	report := []arpDiscrepancy{}
+	var active []LeaseEntry
+	for _, lease := range leases {
+		if addr, err := netip.ParseAddr(lease.IPAddress); err == nil && addr.Unmap().Is4() && lease.Active(now) {
+			active = append(active, lease)
+		}
+	}
+	bar := newProgress(showProgress, "Checking ARP table", len(active)+len(entries))
+	for _, lease := range active {
+		bar.Step()
+		var elsewhere []string
+		atLeasedIP := false
+		for _, entry := range entries {
+			ipMatch, macMatch := sameIP(entry.IP, lease.IPAddress), sameMAC(entry.MAC, lease.MACAddress)
+			switch {
+			case ipMatch && !macMatch:
+				report = append(report, arpDiscrepancy{Status: "ip-conflict", MAC: lease.MACAddress, IP: lease.IPAddress,
+					Hostname: lease.Hostname, Note: "ARP has " + entry.MAC + " at this address on " + entry.Device})
+			case ipMatch:
+				atLeasedIP = true
+			case macMatch:
+				elsewhere = append(elsewhere, entry.IP)
+			}
+		}
+		if !atLeasedIP && len(elsewhere) > 0 {
+			report = append(report, arpDiscrepancy{Status: "mac-moved", MAC: lease.MACAddress, IP: lease.IPAddress,
+				Hostname: lease.Hostname, Note: "ARP has this MAC at " + strings.Join(elsewhere, ", ")})
+		}
+	}
+	for _, entry := range entries {
+		bar.Step()
+		leased := slices.ContainsFunc(active, func(lease LeaseEntry) bool {
+			return sameMAC(entry.MAC, lease.MACAddress) || sameIP(entry.IP, lease.IPAddress)
+		})
+		if !leased {
+			report = append(report, arpDiscrepancy{Status: "no-lease", MAC: entry.MAC, IP: entry.IP,
+				Note: "no active lease (static address?) on " + entry.Device})
+		}
+	}
+	bar.Done()
+	return report
+}
+
+// printARPReport writes the discrepancies as a table with a Status column, or as JSON
+func printARPReport(w io.Writer, report []arpDiscrepancy, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	writer := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "Status\tMAC Address\tIP Address\tHostname\tNote")
+	fmt.Fprintln(writer, "------\t-----------\t----------\t--------\t----")
+	for _, d := range report {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", d.Status, d.MAC, d.IP, d.Hostname, d.Note)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	slog.Info("compared leases with the ARP table", "discrepancies", len(report))
+	return nil
+}
+
+// --- Expiry histogram (--histogram) ---
+
+// histogramWidth is the length of the longest bar
+const histogramWidth = 40
+
+// shortDuration formats a bucket boundary compactly: 30m, 6h, 2d
+func shortDuration(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
+
+// printHistogram writes a text bar chart of the leases bucketed by time until expiry:
+// expired, one bucket per interval between the bounds, beyond the last bound, and never
+func printHistogram(w io.Writer, leases []LeaseEntry, bounds []time.Duration, now time.Time) error {
+	labels := []string{"expired", "< " + shortDuration(bounds[0])}
+	for i := 1; i < len(bounds); i++ {
+		labels = append(labels, shortDuration(bounds[i-1])+"-"+shortDuration(bounds[i]))
+	}
+	labels = append(labels, "> "+shortDuration(bounds[len(bounds)-1]), "never")
+
+	counts := make([]int, len(labels))
+	for _, lease := range leases {
+		switch {
+		case lease.Permanent:
+			counts[len(counts)-1]++
+		case !lease.Active(now):
+			counts[0]++
+		default:
+			// The first bound the remaining time falls below; past the last bound means "> last"
+			remaining := lease.ExpiryTime.Sub(now)
+			bucket := len(bounds) + 1
+			for i, bound := range bounds {
+				if remaining < bound {
+					bucket = i + 1
+					break
+				}
+			}
+			counts[bucket]++
+		}
+	}
+
+	return writeHistogram(w, labels, counts)
+}
+
+// writeHistogram writes one "label |#### count" row per bucket, the bars scaled to the largest count
+func writeHistogram(w io.Writer, labels []string, counts []int) error {
+	largest := slices.Max(counts)
+	writer := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	for i, label := range labels {
+		bar := 0
+		if largest > 0 {
+			bar = (counts[i]*histogramWidth + largest - 1) / largest // Round up so any lease shows
+		}
+		fmt.Fprintf(writer, "%s\t|%s\t%d\n", label, strings.Repeat("#", bar), counts[i])
+	}
+	return writer.Flush()
+}
+
+// maxExpiryBuckets bounds the number of --expiry-histogram rows, so a narrow bucket over
+// a wide range of expiry times fails instead of printing thousands of lines
+const maxExpiryBuckets = 1000
+
+// expiryBucket returns the start of the --histogram-bucket window t falls into on the local
+// wall clock: windows of less than a day start at local midnight (the last one of a day may be
+// shorter), whole-day windows at local midnight every n days
+func expiryBucket(t time.Time, bucket time.Duration) time.Time {
+	t = t.Local()
+	year, month, day := t.Date()
+	if days := int64(bucket / (24 * time.Hour)); bucket%(24*time.Hour) == 0 {
+		epochDay := time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / 86400
+		return time.Date(year, month, day-int((epochDay%days+days)%days), 0, 0, 0, 0, time.Local)
+	}
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+	return midnight.Add(t.Sub(midnight).Truncate(bucket))
+}
+
+// printExpiryHistogram writes a bar chart of the finite leases by the window their expiry
+// time falls into, every window from the earliest to the latest one, to show renewal patterns
+func printExpiryHistogram(w io.Writer, leases []LeaseEntry, bucket time.Duration) error {
+	var first, last time.Time
+	perWindow := map[int64]int{}
+	permanent := 0
+	for _, lease := range leases {
+		if lease.Permanent {
+			permanent++
+			continue
+		}
+		start := expiryBucket(lease.ExpiryTime, bucket)
+		perWindow[start.Unix()]++
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		if start.After(last) {
+			last = start
+		}
+	}
+	layout := "2006-01-02 15:04"
+	next := func(start time.Time) time.Time { return expiryBucket(start.Add(bucket), bucket) }
+	if bucket%(24*time.Hour) == 0 {
+		// Whole days step by date, as a day around a DST change is not 24 hours long
+		layout = "2006-01-02"
+		next = func(start time.Time) time.Time { return start.AddDate(0, 0, int(bucket/(24*time.Hour))) }
+	}
+	var labels []string
+	var counts []int
+	for start := first; len(perWindow) > 0 && !start.After(last); start = next(start) {
+		if len(labels) == maxExpiryBuckets {
+			return fmt.Errorf("--expiry-histogram would print more than %d buckets of %v, use a wider --histogram-bucket", maxExpiryBuckets, bucket)
+		}
+		labels = append(labels, start.Format(layout))
+		counts = append(counts, perWindow[start.Unix()])
+	}
+	if permanent > 0 {
+		labels = append(labels, "never")
+		counts = append(counts, permanent)
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return writeHistogram(w, labels, counts)
+}
+
+// --- Pool analysis (--pool) ---
+
+// maxGapHostBits bounds the pool size --report-gaps will enumerate (2^20 addresses)
+const maxGapHostBits = 20
+
+// parsePools parses the --pool CIDRs
+func parsePools(pools []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(pools))
+	for _, pool := range pools {
+		prefix, err := netip.ParsePrefix(pool)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --pool %q: %w", pool, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// checkConsistency prints the leases whose address lies outside every pool, typically stale
+// leases from a previous dhcp-range, and returns how many there are
+func checkConsistency(w io.Writer, leases []LeaseEntry, poolFlags []string) (int, error) {
+	if len(poolFlags) == 0 {
+		return 0, fmt.Errorf("--check-consistency requires at least one --pool CIDR")
+	}
+	pools, err := parsePools(poolFlags)
+	if err != nil {
+		return 0, err
+	}
+	writer := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	anomalies := 0
+	for _, lease := range leases {
+		addr, err := netip.ParseAddr(lease.IPAddress)
+		if err == nil && slices.ContainsFunc(pools, func(pool netip.Prefix) bool { return pool.Contains(addr.Unmap()) }) {
+			continue
+		}
+		if anomalies == 0 {
+			fmt.Fprintln(writer, "IP Address\tMAC Address\tHostname\tExpiry Time")
+			fmt.Fprintln(writer, "----------\t-----------\t--------\t-----------")
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", lease.IPAddress, lease.MACAddress, lease.Hostname, formatExpiry(lease))
+		anomalies++
+	}
+	if err := writer.Flush(); err != nil {
+		return anomalies, err
+	}
+	if anomalies > 0 {
+		slog.Warn("leases outside the declared pools", "count", anomalies)
+	} else {
+		slog.Info("every lease is inside a declared pool", "leases", len(leases))
+	}
+	return anomalies, nil
+}
+
+// reportGaps prints, one per line, every address in the pools that is not held by an active lease.
+// The network and broadcast addresses of IPv4 pools are never reported as available.
+func reportGaps(w io.Writer, leases []LeaseEntry, pools []string) error {
+	if len(pools) == 0 {
+		return fmt.Errorf("--report-gaps requires at least one --pool CIDR")
+	}
+
+	// Collect the addresses currently in use
+	now := clock()
+	leased := make(map[netip.Addr]bool)
+	for _, lease := range leases {
+		if addr, err := netip.ParseAddr(lease.IPAddress); err == nil && lease.Active(now) {
+			leased[addr.Unmap()] = true
+		}
+	}
+
+	for _, pool := range pools {
+		prefix, err := netip.ParsePrefix(pool)
+		if err != nil {
+			return fmt.Errorf("invalid --pool %q: %w", pool, err)
+		}
+		prefix = prefix.Masked()
+		hostBits := prefix.Addr().BitLen() - prefix.Bits()
+		if hostBits > maxGapHostBits {
+			return fmt.Errorf("pool %s is too large to enumerate", prefix)
+		}
+
+		// Skip the network and broadcast addresses of IPv4 pools larger than /31
+		first, last := prefix.Addr(), lastAddr(prefix)
+		if prefix.Addr().Is4() && prefix.Bits() <= 30 {
+			first, last = first.Next(), last.Prev()
+		}
+
+		free := 0
+		for addr := first; addr.IsValid() && addr.Compare(last) <= 0; addr = addr.Next() {
+			if !leased[addr] {
+				fmt.Fprintln(w, addr)
+				free++
+			}
+		}
+		slog.Info("pool addresses available", "pool", prefix, "available", free)
+	}
+	return nil
+}
+
+// lastAddr returns the highest address within a masked prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	raw := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(raw)*8; bit++ {
+		raw[bit/8] |= 0x80 >> (bit % 8)
+	}
+	addr, _ := netip.AddrFromSlice(raw)
+	return addr
+}
+
+// --- Watch mode (--watch) ---
+
+// leaseKey identifies a lease across polls; renewals only move the expiry time
+func leaseKey(lease LeaseEntry) string {
+	return strings.ToLower(lease.MACAddress) + " " + lease.IPAddress
+}
+
+// leaseChanges describes how the lease set changed between two polls
+type leaseChanges struct {
+	Time    time.Time    `json:"time"`
+	Added   []LeaseEntry `json:"added"`
+	Removed []LeaseEntry `json:"removed"`
+	Changed []LeaseEntry `json:"changed"` // Same MAC and IP, other fields (e.g. expiry) differ
+}
+
+// leaseSetChanges is the --change-webhook payload: leases that appeared or disappeared, ignoring renewals
+type leaseSetChanges struct {
+	Added   []LeaseEntry `json:"added"`
+	Removed []LeaseEntry `json:"removed"`
+}
+
+// Empty reports whether nothing changed
+func (c leaseChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
+// diffLeases compares two polls of the lease file
+func diffLeases(previous, current []LeaseEntry) leaseChanges {
+	changes := leaseChanges{Time: clock()}
+	seen := make(map[string]LeaseEntry, len(previous))
+	for _, lease := range previous {
+		seen[leaseKey(lease)] = lease
+	}
+	present := make(map[string]bool, len(current))
+	for _, lease := range current {
+		present[leaseKey(lease)] = true
+		old, ok := seen[leaseKey(lease)]
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, lease)
+		case old != lease:
+			changes.Changed = append(changes.Changed, lease)
+		}
+	}
+	for _, lease := range previous {
+		if !present[leaseKey(lease)] {
+			changes.Removed = append(changes.Removed, lease)
+		}
+	}
+	return changes
+}
+
+// printDiffLines writes one prefixed line per lease, e.g. "+ <expiry> <mac> <ip> <hostname> <client id>"
+func printDiffLines(w io.Writer, prefix string, leases []LeaseEntry) {
+	for _, lease := range leases {
+		fmt.Fprintf(w, "%s %s %s %s %s %s\n", prefix, formatExpiry(lease), lease.MACAddress, lease.IPAddress, lease.Hostname, lease.ClientID)
+	}
+}
+
+// printChangeLog writes one line per change, e.g. "2024-10-15T09:00:00Z added <mac> <ip> <hostname>",
+// suitable for appending to a log file
+func printChangeLog(w io.Writer, changes leaseChanges) {
+	stamp := changes.Time.Format(time.RFC3339)
+	for _, c := range []struct {
+		kind   string
+		leases []LeaseEntry
+	}{{"added", changes.Added}, {"removed", changes.Removed}, {"changed", changes.Changed}} {
+		for _, lease := range c.leases {
+			fmt.Fprintf(w, "%s %s %s %s %s\n", stamp, c.kind, lease.MACAddress, lease.IPAddress, lease.Hostname)
+		}
+	}
+}
+
+// syslogFacilities are the accepted --syslog-facility names, in the order of their codes
+// (kern is 0, ... ftp is 11; local0 is 16)
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron", "authpriv", "ftp",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// dialLeaseSyslog connects to the --syslog-addr server, or the local syslog daemon when
+// addr is empty. addr is HOST:PORT (UDP) or udp://HOST:PORT or tcp://HOST:PORT.
+func dialLeaseSyslog(addr, facility string) (*syslog.Writer, error) {
+	code := indexOf(syslogFacilities, facility)
+	if code >= 12 {
+		code += 4 // Codes 12-15 are reserved
+	}
+	network, raddr := "", ""
+	if addr != "" {
+		network, raddr = "udp", addr
+		if scheme, rest, ok := strings.Cut(addr, "://"); ok {
+			network, raddr = scheme, rest
+		}
+		if network != "udp" && network != "tcp" {
+			return nil, fmt.Errorf("invalid --syslog-addr %q, expected [udp://|tcp://]HOST:PORT", addr)
+		}
+	}
+	w, err := syslog.Dial(network, raddr, syslog.Priority(code<<3)|syslog.LOG_INFO, programName)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to syslog: %w", err)
+	}
+	return w, nil
+}
+
+// sendSyslogLeases sends one informational syslog message per lease, e.g.
+// "added mac=aa:bb:cc:dd:ee:ff ip=192.168.1.10 hostname=pi client_id=* expiry=2024-05-01T12:00:00Z".
+// event is "lease" for a listing or the kind of change in watch mode.
+func sendSyslogLeases(w *syslog.Writer, event string, leases []LeaseEntry) error {
+	for _, lease := range leases {
+		expiry := "never"
+		if !lease.Permanent {
+			expiry = lease.ExpiryTime.Format(time.RFC3339)
+		}
+		message := fmt.Sprintf("%s mac=%s ip=%s hostname=%s client_id=%s expiry=%s",
+			event, lease.MACAddress, lease.IPAddress, lease.Hostname, lease.ClientID, expiry)
+		if err := w.Info(message); err != nil {
+			return fmt.Errorf("sending to syslog: %w", err)
+		}
+	}
+	return nil
+}
+
+// printSyslog sends the leases for --format syslog outside watch mode; nothing is
+// written to w, the output goes to the syslog server
+func printSyslog(leases []LeaseEntry, opts options) error {
+	sysw, err := dialLeaseSyslog(opts.SyslogAddr, opts.SyslogFacility)
+	if err != nil {
+		return err
+	}
+	defer sysw.Close()
+	if err := sendSyslogLeases(sysw, "lease", leases); err != nil {
+		return err
+	}
+	slog.Info("sent leases to syslog", "count", len(leases))
+	return nil
+}
+
+// postWebhook POSTs the payload as JSON, retrying with exponential backoff on failure
+func postWebhook(url string, payload any, timeout time.Duration, retries int) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encoding webhook payload: %w", err)
+	}
+	client := &http.Client{Timeout: timeout}
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("webhook %s returned %s", url, resp.Status)
+		}
+		if attempt >= retries {
+			return err
+		}
+		slog.Warn("webhook request failed, retrying", "error", err, "backoff", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// statFingerprint summarizes the size and modification time of the files
+func statFingerprint(paths []string, timeout time.Duration) string {
+	var b strings.Builder
+	for _, path := range paths {
+		if info, err := statTimeout(path, timeout); err == nil {
+			fmt.Fprintf(&b, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		} else {
+			fmt.Fprintf(&b, "%s missing\n", path)
+		}
+	}
+	return b.String()
+}
+
+// contentFingerprint hashes the contents of the files
+func contentFingerprint(paths []string, timeout time.Duration) string {
+	h := sha256.New()
+	for _, path := range paths {
+		if data, err := withReadTimeout(timeout, "reading "+path, func() ([]byte, error) { return os.ReadFile(path) }); err == nil {
+			h.Write(data)
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// runWatch polls the lease files every --interval forever, re-printing the table or, with --watch-diff
+// or --changes-only, the changes. The files are only re-read when they changed (mtime and size,
+// then content), but the leases are filtered again on every poll, as expiry moves on, and the
+// table is redrawn whenever the output differs from the screen.
+func runWatch(w io.Writer, paths []string, read func() ([]LeaseEntry, error), process func([]LeaseEntry) []LeaseEntry, opts options) {
+	var sysw *syslog.Writer
+	if opts.Format == "syslog" {
+		var err error
+		if sysw, err = dialLeaseSyslog(opts.SyslogAddr, opts.SyslogFacility); err != nil {
+			fatalf("%v", err)
+		}
+		defer sysw.Close()
+	}
+	var parsed, previous []LeaseEntry
+	var lastStat, lastContent string
+	var screen []byte
+	stale := true // parsed does not reflect the files yet
+	first := true
+	for {
+		if !first {
+			time.Sleep(opts.WatchInterval)
+		}
+		if stat := statFingerprint(paths, opts.ReadTimeout); stale || stat != lastStat {
+			// A touched but identical file need not be parsed again
+			content := contentFingerprint(paths, opts.ReadTimeout)
+			stale = stale || content != lastContent
+			lastStat, lastContent = stat, content
+		}
+		if stale {
+			var err error
+			if parsed, err = read(); err != nil {
+				// A transient error (e.g. the file being replaced) should not stop watching
+				slog.Warn("reading leases failed", "error", err)
+				first = false
+				continue
+			}
+			stale = false
+		}
+		leases := process(parsed)
+
+		var changes leaseChanges
+		if !first {
+			changes = diffLeases(previous, leases)
+		}
+
+		if sysw != nil {
+			// One message per change; like --changes-only, nothing for the leases already there
+			for _, c := range []struct {
+				kind   string
+				leases []LeaseEntry
+			}{{"added", changes.Added}, {"removed", changes.Removed}, {"changed", changes.Changed}} {
+				if err := sendSyslogLeases(sysw, c.kind, c.leases); err != nil {
+					slog.Warn("syslog delivery failed", "error", err)
+				}
+			}
+		} else if opts.ChangesOnly {
+			printChangeLog(w, changes) // Nothing on the first poll: the log starts from the current state
+		} else if opts.WatchDiff {
+			if first {
+				printTable(w, leases, opts) // Start from the full picture, then stream changes
+			} else {
+				printDiffLines(w, "+", changes.Added)
+				printDiffLines(w, "-", changes.Removed)
+			}
+		} else {
+			var b bytes.Buffer
+			if err := render(&b, leases, opts); err != nil {
+				fatalf("%v", err)
+			}
+			if !bytes.Equal(b.Bytes(), screen) {
+				// Redrawing an unchanged table would only flicker on slow terminals
+				fmt.Fprint(w, "\x1b[H\x1b[2J") // Clear the screen before redrawing
+				fmt.Fprintf(w, "Every %s: %s\n\n", opts.WatchInterval, clock().Format("2006-01-02 15:04:05"))
+				w.Write(b.Bytes())
+				screen = b.Bytes()
+			}
+		}
+
+		if opts.Webhook != "" && !changes.Empty() {
+			if err := postWebhook(opts.Webhook, changes, opts.WebhookTimeout, opts.WebhookRetries); err != nil {
+				slog.Warn("webhook delivery failed", "error", err)
+			}
+		}
+		if opts.ChangeWebhook != "" && (len(changes.Added) > 0 || len(changes.Removed) > 0) {
+			// All changes of one poll go out in a single request
+			payload := leaseSetChanges{Added: changes.Added, Removed: changes.Removed}
+			if payload.Added == nil {
+				payload.Added = []LeaseEntry{}
+			}
+			if payload.Removed == nil {
+				payload.Removed = []LeaseEntry{}
+			}
+			if err := postWebhook(opts.ChangeWebhook, payload, opts.WebhookTimeout, opts.WebhookRetries); err != nil {
+				slog.Warn("change webhook delivery failed", "error", err)
+			}
+		}
+
+		previous, first = leases, false
+	}
+}
+
+// tailPollInterval is how often TailLeaseFile checks the file for changes
+const tailPollInterval = time.Second
+
+// TailLeaseFile watches a lease file and sends every newly appearing lease to ch,
+// starting with the leases already in the file. Appended lines are read
+// incrementally; when dnsmasq rewrites the whole file (it is replaced, or the
+// bytes read before changed, whatever the new size), it is re-read and only
+// leases not seen before are sent.
+// The channel is closed when ctx is canceled.
+func TailLeaseFile(ctx context.Context, path string, ch chan<- LeaseEntry) {
+	tailLeaseFile(ctx, path, ch, ParseOptions{TagsColumn: "auto"}, 0)
+}
+
+// tailLeaseFile is TailLeaseFile parsing with the given options; a stat or read of the
+// file that takes longer than timeout (0: no limit) is reported and retried on the next poll
+func tailLeaseFile(ctx context.Context, path string, ch chan<- LeaseEntry, parseOpts ParseOptions, timeout time.Duration) {
+	defer close(ch)
+
+	var (
+		info    os.FileInfo         // File identity, size and mtime at the last read
+		offset  int64               // Bytes consumed so far
+		prefix  [sha256.Size]byte   // Hash of those bytes, to tell an append from a rewrite
+		partial string              // Incomplete trailing line waiting for its newline
+		seen    = map[string]bool{} // Hashes of the leases in the current file
+	)
+
+	// send delivers a lease unless it was already sent for this file; false means ctx ended
+	send := func(lease LeaseEntry, current map[string]bool) bool {
+		hash := lease.Hash()
+		alreadySent := seen[hash]
+		current[hash] = true
+		seen[hash] = true
+		if alreadySent {
+			return true
+		}
+		select {
+		case ch <- lease:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+	for {
+		if stat, err := statTimeout(path, timeout); err != nil {
+			slog.Warn("cannot stat lease file", "error", err) // The file may be between rename and create
+		} else {
+			unchanged := info != nil && os.SameFile(info, stat) && stat.Size() == offset && stat.ModTime().Equal(info.ModTime())
+			if unchanged {
+				// Nothing new
+			} else if data, err := withReadTimeout(timeout, "reading "+path, func() ([]byte, error) { return os.ReadFile(path) }); err != nil {
+				slog.Warn("reading lease file failed", "error", err)
+			} else {
+				// dnsmasq rewrites the file in place on the same inode, so growth alone does not
+				// mean an append: only if the bytes read before are still there is the rest new
+				rewritten := info == nil || !os.SameFile(info, stat) || int64(len(data)) < offset || sha256.Sum256(data[:offset]) != prefix
+				if rewritten {
+					offset, partial = 0, ""
+				}
+				info = stat
+				text := partial + string(data[offset:])
+				offset, prefix = int64(len(data)), sha256.Sum256(data)
+				lines := strings.Split(text, "\n")
+				partial = lines[len(lines)-1] // Keep an unterminated last line for the next poll
+				current := seen
+				if rewritten {
+					current = map[string]bool{}
+				}
+				scanner := NewLeaseScanner(strings.NewReader(strings.Join(lines[:len(lines)-1], "\n")))
+				scanner.Options = parseOpts
+				for scanner.Scan() {
+					if warning := scanner.Warning(); warning != nil {
+						slog.Warn("skipping malformed line", "file", path, "reason", warning.Err)
+						continue
+					}
+					lease := scanner.Lease()
+					lease.Source = path
+					if !send(lease, current) {
+						return
+					}
+				}
+				if rewritten {
+					seen = current // Forget leases that disappeared with the rewrite
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runFollow streams newly appearing leases of a single file until interrupted
+func runFollow(w io.Writer, path string, opts options) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// The layout can't be detected up front, so an optional tags column is accepted per line
+	parseOpts := leaseParseOptions(opts)
+	if parseOpts.TagsColumn != "no" {
+		parseOpts.TagsColumn = "auto"
+	}
+	ch := make(chan LeaseEntry)
+	go tailLeaseFile(ctx, path, ch, parseOpts, opts.ReadTimeout)
+	for lease := range ch {
+		if len(filterLeases([]LeaseEntry{lease}, opts)) == 0 {
+			continue
+		}
+		printDiffLines(w, "+", []LeaseEntry{lease})
+	}
+}
+
+// --- Interactive browser (--tui) ---
+
+// tuiColumns are the column headers of the interactive view, in sort-key order
+var tuiColumns = []string{"Expiry Time", "MAC Address", "IP Address", "Hostname", "Client ID"}
+
+// tuiShowModes are the states cycled through by the active/expired toggle
+var tuiShowModes = []string{"all", "active", "expired"}
+
+// tuiState is the view state of the interactive browser
+type tuiState struct {
+	leases     []LeaseEntry // All leases from the last (re)load
+	filter     string       // Case-insensitive substring filter
+	editing    bool         // True while the filter is being typed
+	sortColumn int          // Index into tuiColumns
+	sortDesc   bool         // Reverse the sort order
+	showMode   int          // Index into tuiShowModes
+	offset     int          // First visible row
+	status     string       // Message shown in the footer
+	height     int          // Terminal rows, refreshed on SIGWINCH
+	width      int          // Terminal columns, refreshed on SIGWINCH
+}
+
+// visible returns the filtered and sorted leases for the current view state
+func (s *tuiState) visible() []LeaseEntry {
+	now := clock()
+	needle := strings.ToLower(s.filter)
+	var rows []LeaseEntry
+	for _, lease := range s.leases {
+		switch tuiShowModes[s.showMode] {
+		case "active":
+			if !lease.Active(now) {
+				continue
+			}
+		case "expired":
+			if lease.Active(now) {
+				continue
+			}
+		}
+		haystack := strings.ToLower(strings.Join([]string{lease.MACAddress, lease.IPAddress, lease.Hostname, lease.ClientID}, " "))
+		if needle != "" && !strings.Contains(haystack, needle) {
+			continue
+		}
+		rows = append(rows, lease)
+	}
+	return SortLeases(rows, SortField(s.sortColumn), s.sortDesc)
+}
+
+// terminalSize asks stty for the terminal dimensions, defaulting to 24x80
+func terminalSize() (rows, cols int) {
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	if err == nil {
+		if _, err := fmt.Sscan(string(out), &rows, &cols); err == nil && rows > 0 {
+			return rows, cols
+		}
+	}
+	return 24, 80
+}
+
+// draw renders the full screen for the current state
+func (s *tuiState) draw(w io.Writer) {
+	rows := s.visible()
+	width := s.width
+	pageSize := s.height - 4 // Title, header, and two footer lines
+	if pageSize < 1 {
+		pageSize = 1
+	}
+	if s.offset > len(rows)-pageSize {
+		s.offset = len(rows) - pageSize
+	}
+	if s.offset < 0 {
+		s.offset = 0
+	}
+
+	// Lay out all rows with tabwriter so column widths do not jump while scrolling
+	var table bytes.Buffer
+	writer := tabwriter.NewWriter(&table, 0, 8, 2, ' ', 0)
+	header := make([]string, len(tuiColumns))
+	for i, name := range tuiColumns {
+		header[i] = fmt.Sprintf("%d:%s", i+1, name)
+		if i == s.sortColumn {
+			if s.sortDesc {
+				header[i] += " v"
+			} else {
+				header[i] += " ^"
+			}
+		}
+	}
+	fmt.Fprintln(writer, strings.Join(header, "\t"))
+	for _, lease := range rows {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", formatExpiry(lease), lease.MACAddress, lease.IPAddress, lease.Hostname, lease.ClientID)
+	}
+	writer.Flush()
+	lines := strings.Split(strings.TrimRight(table.String(), "\n"), "\n")
+
+	var screen bytes.Buffer
+	screen.WriteString("\x1b[H\x1b[2J") // Move home and clear the screen
+	fmt.Fprintf(&screen, "dnsmasq leases: %d shown of %d, showing %s\r\n", len(rows), len(s.leases), tuiShowModes[s.showMode])
+	screen.WriteString("\x1b[7m" + clip(lines[0], width) + "\x1b[0m\r\n")
+	end := s.offset + pageSize
+	if end > len(rows) {
+		end = len(rows)
+	}
+	for _, line := range lines[1+s.offset : 1+end] {
+		screen.WriteString(clip(line, width) + "\r\n")
+	}
+	for i := end - s.offset; i < pageSize; i++ {
+		screen.WriteString("\r\n") // Pad so the footer stays at the bottom
+	}
+	if s.editing {
+		fmt.Fprintf(&screen, "Filter: %s_\r\n", s.filter)
+	} else {
+		fmt.Fprintf(&screen, "Filter: %s  %s\r\n", s.filter, s.status)
+	}
+	screen.WriteString(clip("q quit  / filter  1-5 sort  a active/expired  r reload  j/k scroll  PgUp/PgDn page", width))
+	w.Write(screen.Bytes())
+}
+
+// clip truncates a line to the terminal width
+func clip(line string, width int) string {
+	runes := []rune(line)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+	return line
+}
+
+// runTUI runs the interactive lease browser until the user quits.
+// load is called on start and whenever the user asks to reload.
+func runTUI(load func() ([]LeaseEntry, error)) error {
+	// Remember the terminal settings so they can be restored on exit
+	saveCmd := exec.Command("stty", "-g")
+	saveCmd.Stdin = os.Stdin
+	saved, err := saveCmd.Output()
+	if err != nil {
+		return fmt.Errorf("--tui requires an interactive terminal: %w", err)
+	}
+	// -isig delivers Ctrl-C as a key instead of a signal that would skip restoring the terminal
+	rawCmd := exec.Command("stty", "-icanon", "-echo", "-isig", "min", "1")
+	rawCmd.Stdin = os.Stdin
+	if err := rawCmd.Run(); err != nil {
+		return fmt.Errorf("cannot switch terminal to raw mode: %w", err)
+	}
+	fmt.Print("\x1b[?1049h\x1b[?25l") // Alternate screen, hidden cursor
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		restoreCmd := exec.Command("stty", strings.TrimSpace(string(saved)))
+		restoreCmd.Stdin = os.Stdin
+		restoreCmd.Run()
+	}()
+
+	state := &tuiState{}
+	state.height, state.width = terminalSize()
+	reload := func() {
+		leases, err := load()
+		if err != nil {
+			state.status = fmt.Sprintf("reload failed: %v", err)
+			return
+		}
+		state.leases = leases
+		state.status = "loaded at " + clock().Format("15:04:05")
+	}
+	reload()
+
+	// Asking stty for the size on every redraw forks a process per keypress, so it is
+	// only asked again when the window is resized. mu keeps that redraw from
+	// interleaving with a keypress; it is held except while waiting for input.
+	var mu sync.Mutex
+	done := false
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	go func() {
+		for range resized {
+			height, width := terminalSize()
+			mu.Lock()
+			if !done {
+				state.height, state.width = height, width
+				state.draw(os.Stdout)
+			}
+			mu.Unlock()
+		}
+	}()
+	mu.Lock()
+	defer mu.Unlock()
+	defer func() {
+		signal.Stop(resized)
+		close(resized)
+		done = true
+	}()
+
+	input := bufio.NewReader(os.Stdin)
+	for {
+		state.draw(os.Stdout)
+		mu.Unlock()
+		key, err := input.ReadByte()
+		mu.Lock()
+		if err != nil {
+			return nil // Input closed, nothing more to do
+		}
+
+		if state.editing {
+			switch key {
+			case '\r', '\n':
+				state.editing = false
+			case 0x7f, 0x08: // Backspace
+				if r := []rune(state.filter); len(r) > 0 {
+					state.filter = string(r[:len(r)-1])
+				}
+			case 0x15: // Ctrl-U clears the filter
+				state.filter = ""
+			case 0x03: // Ctrl-C
+				return nil
+			case 0x1b: // Arrow and other special keys are not part of the filter
+				readEscape(input)
+			default:
+				if key >= 0x20 {
+					input.UnreadByte()
+					r, _, _ := input.ReadRune()
+					state.filter += string(r)
+				}
+			}
+			state.offset = 0
+			continue
+		}
+
+		page := state.height - 4 // Rows per page, matching draw
+		switch key {
+		case 'q', 0x03: // q or Ctrl-C
+			return nil
+		case '/':
+			state.editing = true
+		case '1', '2', '3', '4', '5':
+			column := int(key - '1')
+			if column == state.sortColumn {
+				state.sortDesc = !state.sortDesc // Same column again flips the order
+			} else {
+				state.sortColumn, state.sortDesc = column, false
+			}
+		case 'a':
+			state.showMode = (state.showMode + 1) % len(tuiShowModes)
+			state.offset = 0
+		case 'r':
+			reload()
+		case 'j':
+			state.offset++
+		case 'k':
+			state.offset--
+		case 0x1b: // Escape sequences for arrow and paging keys
+			switch params, final := readEscape(input); {
+			case final == 'A':
+				state.offset--
+			case final == 'B':
+				state.offset++
+			case final == '~' && params == "5":
+				state.offset -= page
+			case final == '~' && params == "6":
+				state.offset += page
+			}
+		}
+	}
+}
+
+// readEscape reads the rest of an escape sequence after ESC and returns its parameters
+// and final byte, e.g. "5" and '~' for Page Up or "" and 'A' for the up arrow.
+// Anything but a CSI (ESC [) or SS3 (ESC O) sequence yields a zero final byte.
+func readEscape(input *bufio.Reader) (params string, final byte) {
+	intro, err := input.ReadByte()
+	if err != nil {
+		return "", 0
+	}
+	switch intro {
+	case 'O': // SS3, sent for the arrows in application cursor mode
+		final, _ = input.ReadByte()
+		return "", final
+	case '[':
+	default:
+		return "", 0
+	}
+	var p []byte
+	for {
+		b, err := input.ReadByte()
+		if err != nil {
+			return "", 0
+		}
+		if b >= 0x40 && b <= 0x7e {
+			return string(p), b
+		}
+		p = append(p, b)
+	}
+}
+
+// --- Metrics (--format prometheus, --remote-write) ---
+
+// influxTagEscaper escapes tag values for InfluxDB line protocol
+var influxTagEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `)
+
+// printInflux writes one InfluxDB line-protocol point per lease, e.g.
+//
+//	dnsmasq_lease,mac=aa:bb:cc:dd:ee:ff,ip=192.168.1.5,hostname=laptop expiry_seconds=3600i,permanent=false 1700000000000000000
+//
+// expiry_seconds (negative once expired) is left out for infinite leases.
+func printInflux(w io.Writer, leases []LeaseEntry, now time.Time) error {
+	for _, lease := range leases {
+		fields := fmt.Sprintf("permanent=%t", lease.Permanent)
+		if !lease.Permanent {
+			fields = fmt.Sprintf("expiry_seconds=%di,%s", int64(lease.ExpiryTime.Sub(now)/time.Second), fields)
+		}
+		_, err := fmt.Fprintf(w, "dnsmasq_lease,mac=%s,ip=%s,hostname=%s %s %d\n",
+			influxTagEscaper.Replace(lease.MACAddress), influxTagEscaper.Replace(lease.IPAddress),
+			influxTagEscaper.Replace(lease.Hostname), fields, now.UnixNano())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metricLabel is a single name="value" label pair
+type metricLabel struct {
+	Name, Value string
+}
+
+// metricSample is one gauge value with its labels
+type metricSample struct {
+	Name   string
+	Help   string
+	Labels []metricLabel
+	Value  float64
+}
+
+// metricNamePattern and labelNamePattern are the valid Prometheus metric and label names
+var (
+	metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNamePattern  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// parseMetricLabel parses a constant "key=value" label; the per-lease label names are reserved
+func parseMetricLabel(pair string) (metricLabel, error) {
+	name, value, ok := strings.Cut(pair, "=")
+	switch {
+	case !ok:
+		return metricLabel{}, fmt.Errorf("%q is not key=value", pair)
+	case !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__"):
+		return metricLabel{}, fmt.Errorf("invalid label name %q", name)
+	case name == "hostname" || name == "ip" || name == "mac":
+		return metricLabel{}, fmt.Errorf("label name %q is already used for every lease", name)
+	}
+	return metricLabel{name, value}, nil
+}
+
+// leaseMetrics summarizes the leases as gauges: totals plus the remaining seconds of every lease.
+// Every metric name starts with prefix and carries the constant labels.
+func leaseMetrics(leases []LeaseEntry, now time.Time, prefix string, constant []metricLabel) []metricSample {
+	var active, expired, permanent int
+	var samples []metricSample
+	for _, lease := range leases {
+		switch {
+		case lease.Permanent:
+			permanent++
+			active++
+		case lease.Active(now):
+			active++
+		default:
+			expired++
+		}
+		remaining := 0.0
+		if !lease.Permanent {
+			remaining = lease.ExpiryTime.Sub(now).Truncate(time.Second).Seconds()
+		}
+		samples = append(samples, metricSample{
+			Name: prefix + "lease_expiry_seconds",
+			Help: "Seconds until the lease expires (negative once expired, 0 for permanent leases).",
+			Labels: append(slices.Clone(constant),
+				metricLabel{"hostname", lease.Hostname},
+				metricLabel{"ip", lease.IPAddress},
+				metricLabel{"mac", lease.MACAddress},
+			),
+			Value: remaining,
+		})
+	}
+	totals := []metricSample{
+		{Name: prefix + "leases", Help: "Number of leases in the lease file.", Labels: constant, Value: float64(len(leases))},
+		{Name: prefix + "leases_active", Help: "Number of leases that have not expired.", Labels: constant, Value: float64(active)},
+		{Name: prefix + "leases_expired", Help: "Number of expired leases.", Labels: constant, Value: float64(expired)},
+		{Name: prefix + "leases_permanent", Help: "Number of infinite leases.", Labels: constant, Value: float64(permanent)},
+	}
+	return append(totals, samples...)
+}
+
+// printPrometheus writes the samples in the Prometheus text exposition format
+func printPrometheus(w io.Writer, samples []metricSample) error {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	described := map[string]bool{}
+	for _, sample := range samples {
+		if !described[sample.Name] {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", sample.Name, sample.Help, sample.Name)
+			described[sample.Name] = true
+		}
+		labels := make([]string, len(sample.Labels))
+		for i, label := range sample.Labels {
+			labels[i] = fmt.Sprintf(`%s="%s"`, label.Name, escape.Replace(label.Value))
+		}
+		name := sample.Name
+		if len(labels) > 0 {
+			name += "{" + strings.Join(labels, ",") + "}"
+		}
+		if _, err := fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(sample.Value, 'f', -1, 64)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushRemoteWrite sends the samples to a Prometheus remote-write endpoint
+// as a snappy-compressed protobuf WriteRequest
+func pushRemoteWrite(url, auth string, samples []metricSample) error {
+	body := snappyEncode(encodeWriteRequest(samples, time.Now()))
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("remote write to %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote write to %s returned %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// encodeWriteRequest encodes the samples as a prometheus.WriteRequest protobuf message:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(samples []metricSample, now time.Time) []byte {
+	var request []byte
+	for _, sample := range samples {
+		// Remote write requires the metric name as the __name__ label and labels sorted by name
+		labels := append([]metricLabel{{"__name__", sample.Name}}, sample.Labels...)
+		sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+		var series []byte
+		for _, label := range labels {
+			var l []byte
+			l = protoBytes(l, 1, []byte(label.Name))
+			l = protoBytes(l, 2, []byte(label.Value))
+			series = protoBytes(series, 1, l)
+		}
+		var s []byte
+		s = protowireTag(s, 1, 1) // Fixed 64-bit double
+		s = binary.LittleEndian.AppendUint64(s, math.Float64bits(sample.Value))
+		s = protowireTag(s, 2, 0) // Varint
+		s = binary.AppendUvarint(s, uint64(now.UnixMilli()))
+		series = protoBytes(series, 2, s)
+
+		request = protoBytes(request, 1, series)
+	}
+	return request
+}
+
+// protowireTag appends a protobuf field key
+func protowireTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+// protoBytes appends a length-delimited protobuf field
+func protoBytes(b []byte, field int, value []byte) []byte {
+	b = protowireTag(b, field, 2)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// snappyEncode produces a valid snappy block using literal chunks only.
+// The payload is small, so skipping compression costs little and avoids a dependency.
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(nil, uint64(len(src))) // Uncompressed length preamble
+	for len(src) > 0 {
+		chunk := src
+		if len(chunk) > 65536 {
+			chunk = chunk[:65536]
+		}
+		n := len(chunk) - 1
+		switch {
+		case n < 60:
+			dst = append(dst, byte(n)<<2)
+		case n < 1<<8:
+			dst = append(dst, 60<<2, byte(n))
+		default:
+			dst = append(dst, 61<<2, byte(n), byte(n>>8))
+		}
+		dst = append(dst, chunk...)
+		src = src[len(chunk):]
+	}
+	return dst
+}
+
+// --- Shell completion (--completion) ---
+
+// programName is the command name completion scripts are registered for
+const programName = "parse-dnsmasq-lease"
+
+// completionFlag describes one flag for the completion generators
+type completionFlag struct {
+	Name    string   // Flag name without dashes
+	Usage   string   // Help text
+	IsBool  bool     // Takes no value
+	Choices []string // Fixed values, if any
+	IsFile  bool     // Value is a path
+}
+
+// completionFlags collects every registered flag, so new flags are picked up automatically
+func completionFlags() []completionFlag {
+	var flags []completionFlag
+	flag.VisitAll(func(f *flag.Flag) {
+		boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{
+			Name:    f.Name,
+			Usage:   f.Usage,
+			IsBool:  ok && boolFlag.IsBoolFlag(),
+			Choices: flagChoices[f.Name],
+			IsFile:  fileFlags[f.Name],
+		})
+	})
+	return flags
+}
+
+// completionCommands returns the sub-command names in order, offered as the first word
+func completionCommands() []string {
+	var names []string
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// printCompletion writes the completion script for the named shell
+func printCompletion(w io.Writer, shell string) error {
+	flags := completionFlags()
+	switch shell {
+	case "bash":
+		return printBashCompletion(w, flags)
+	case "zsh":
+		return printZshCompletion(w, flags)
+	case "fish":
+		return printFishCompletion(w, flags)
+	default:
+		return fmt.Errorf("unsupported shell %q for --completion, expected bash, zsh or fish", shell)
+	}
+}
+
+func printBashCompletion(w io.Writer, flags []completionFlag) error {
+	var names []string
+	commands := completionCommands()
+	fmt.Fprintf(w, "# bash completion for %s\n", programName)
+	fmt.Fprintln(w, "_parse_dnsmasq_lease() {")
+	fmt.Fprintln(w, "    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"")
+	fmt.Fprintln(w, "    case \"$prev\" in")
+	for _, f := range flags {
+		names = append(names, "--"+f.Name)
+		switch {
+		case len(f.Choices) > 0:
+			fmt.Fprintf(w, "        --%s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")); return ;;\n", f.Name, strings.Join(f.Choices, " "))
+		case f.IsFile:
+			fmt.Fprintf(w, "        --%s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", f.Name)
+		case !f.IsBool:
+			fmt.Fprintf(w, "        --%s) return ;;\n", f.Name) // Free-form value
+		}
+	}
+	fmt.Fprintln(w, "    esac")
+	// Offer the sub-commands until one has been typed, then only flags
+	fmt.Fprintf(w, "    local word flags=\"%s\"\n", strings.Join(names, " "))
+	fmt.Fprintln(w, "    for word in \"${COMP_WORDS[@]:1:COMP_CWORD-1}\"; do")
+	fmt.Fprintf(w, "        case \"$word\" in %s) COMPREPLY=($(compgen -W \"$flags\" -- \"$cur\")); return ;; esac\n", strings.Join(commands, "|"))
+	fmt.Fprintln(w, "    done")
+	fmt.Fprintf(w, "    COMPREPLY=($(compgen -W \"%s $flags\" -- \"$cur\"))\n", strings.Join(commands, " "))
+	fmt.Fprintln(w, "}")
+	_, err := fmt.Fprintf(w, "complete -F _parse_dnsmasq_lease %s\n", programName)
+	return err
+}
+
+func printZshCompletion(w io.Writer, flags []completionFlag) error {
+	// _arguments uses [] for descriptions and : as a separator, so both are escaped
+	escape := strings.NewReplacer("[", "\\[", "]", "\\]", ":", "\\:", "'", "'\\''")
+	fmt.Fprintf(w, "#compdef %s\n\n", programName)
+	fmt.Fprintln(w, "_arguments \\")
+	for _, f := range flags {
+		spec := fmt.Sprintf("--%s[%s]", f.Name, escape.Replace(f.Usage))
+		switch {
+		case len(f.Choices) > 0:
+			spec += fmt.Sprintf(":%s:(%s)", f.Name, strings.Join(f.Choices, " "))
+		case f.IsFile:
+			spec += fmt.Sprintf(":%s:_files", f.Name)
+		case !f.IsBool:
+			spec += fmt.Sprintf(":%s:", f.Name)
+		}
+		fmt.Fprintf(w, "  '%s' \\\n", spec)
+	}
+	fmt.Fprintf(w, "  '1:command:(%s)' \\\n", strings.Join(completionCommands(), " "))
+	_, err := fmt.Fprintln(w, "  && return 0")
+	return err
+}
+
+func printFishCompletion(w io.Writer, flags []completionFlag) error {
+	escape := strings.NewReplacer("'", "\\'")
+	fmt.Fprintf(w, "# fish completion for %s\n", programName)
+	for _, name := range completionCommands() {
+		_, usage, _ := strings.Cut(subcommands[name], "  ")
+		line := fmt.Sprintf("complete -c %s -n __fish_use_subcommand -f -a %s -d '%s'", programName, name, escape.Replace(strings.TrimSpace(usage)))
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	for _, f := range flags {
+		line := fmt.Sprintf("complete -c %s -l %s -d '%s'", programName, f.Name, escape.Replace(f.Usage))
+		switch {
+		case len(f.Choices) > 0:
+			line += fmt.Sprintf(" -x -a '%s'", strings.Join(f.Choices, " "))
+		case f.IsFile:
+			line += " -r -F"
+		case !f.IsBool:
+			line += " -x"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	opts := parseFlags()
+	if err := startProfiling(opts.ProfileCPU, opts.ProfileMem); err != nil {
+		fatalf("%v", err)
+	}
+	defer stopProfiling()
+
+	if opts.Completion != "" {
+		if err := printCompletion(os.Stdout, opts.Completion); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	if opts.ListFields {
+		if err := printFieldList(os.Stdout, opts); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	if opts.Command == "update-vendor-db" {
+		count, err := updateVendorFile(opts.VendorFile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		slog.Info("vendor database updated", "file", opts.VendorFile, "prefixes", count)
+		return
+	}
+	if opts.VendorFile != "" {
+		db, err := loadVendorFile(opts.VendorFile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		vendorDB = db
+	}
+
+	// Determine the lease file paths
+	paths, err := leaseFilePaths(opts)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	// A glob usually merges the files of several dnsmasq instances, so show where each lease came from
+	if len(paths) > 1 && (isGlob(os.Getenv(envVarLeasePath)) || slices.ContainsFunc(opts.Files, isGlob)) {
+		opts.Source = true
+	}
+
+	if opts.Command == "validate" {
+		ok, err := validateLeaseFiles(os.Stdout, paths, opts)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if !ok {
+			exit(1)
+		}
+		return
+	}
+
+	// read parses the lease files, before any filtering
+	read := func() ([]LeaseEntry, error) {
+		leases, err := parseLeaseFiles(paths, opts)
+		if err != nil {
+			return nil, err
+		}
+		if opts.CheckFuture {
+			// Every parsed lease is checked, including those the filters drop
+			warnFutureLeases(leases, opts.FutureMax, clock())
+		}
+		if opts.Log != "" {
+			attachLogStarts(leases, opts.Log)
+		}
+		return leases, nil
+	}
+	// process filters, orders and anonymizes what read returned
+	process := func(leases []LeaseEntry) []LeaseEntry {
+		leases = filterLeases(leases, opts)
+		if opts.DedupeIP {
+			leases = dedupeByIP(leases)
+		}
+		if opts.PerMACMax > 0 {
+			leases = leasesOfBusyMACs(leases, opts.PerMACMax)
+		}
+		if opts.Sample > 0 {
+			leases = sampleLeases(leases, opts.Sample, opts.Seed)
+		}
+		// Anonymized before sorting, so the order does not reveal the original values
+		if opts.Anonymize {
+			anonymizeLeases(leases, opts.salt, opts.BucketIPs, opts.MACCase)
+		} else if opts.MACAnonymize {
+			anonymizeMACs(leases, opts.salt, opts.MACCase)
+		}
+		sortLeases(leases, opts)
+		return leases
+	}
+	// load reads and processes the leases; watch mode and the browser call it repeatedly
+	load := func() ([]LeaseEntry, error) {
+		leases, err := read()
+		if err != nil {
+			return nil, err
+		}
+		return process(leases), nil
+	}
+
+	// The interactive browser re-reads the file itself on demand
+	if opts.TUI {
+		if err := runTUI(load); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	if opts.Follow {
+		if len(paths) != 1 {
+			fatalf("--follow works with a single lease file, got %d", len(paths))
+		}
+		runFollow(os.Stdout, paths[0], opts)
+		return
+	}
+
+	if opts.Watch {
+		runWatch(os.Stdout, paths, read, process, opts)
+		return
+	}
+
+	if opts.Command == "wait" {
+		timeout, _ := time.ParseDuration(opts.Args[1]) // Validated by parseFlags
+		exit(lookupStatus(waitForLease(os.Stdout, load, opts.Args[0], timeout)))
+	}
+
+	// A stale lease file still gets listed or looked up, but the exit status reports it
+	status := 0
+	if opts.MaxAge > 0 && staleLeaseFiles(paths, opts.MaxAge, clock(), opts.ReadTimeout) {
+		status = 1
+	}
+	defer func() {
+		if status != 0 {
+			exit(status)
+		}
+	}()
+
+	leases, err := read()
+	if err != nil {
+		// If the file is not found or permissions are denied, log the error and exit
+		fatalf("%v", err)
+	}
+	if opts.NewSinceLast {
+		// Compared before filtering, so a MAC hidden by today's filters is still remembered
+		// and the state file never holds anonymized MACs
+		if leases, err = newSinceLast(leases, opts.StateFile); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	leases = process(leases)
+
+	if opts.Command == "count" {
+		fmt.Println(countLeases(leases, opts.Args, clock()))
+		return
+	}
+	if opts.Command == "stats" {
+		if err := printStats(os.Stdout, leases, clock()); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+	if opts.Command == "show" {
+		exit(max(status, lookupStatus(showLease(os.Stdout, leases, opts.Args[0], clock()))))
+	}
+	if opts.Command == "lookup" {
+		exit(max(status, lookupStatus(lookupLease(os.Stdout, leases, opts.Args[0], clock(), opts.Resolve, opts.Progress))))
+	}
+	if opts.MACToIP != "" {
+		exit(max(status, lookupStatus(lookupMACToIP(os.Stdout, leases, opts.MACToIP))))
+	}
+	if opts.IPToMAC != "" {
+		exit(max(status, lookupStatus(lookupIPToMAC(os.Stdout, leases, opts.IPToMAC, opts.WithHostname))))
+	}
+	if opts.IPToHostname != "" {
+		exit(max(status, lookupStatus(lookupIPToHostname(os.Stdout, leases, opts.IPToHostname))))
+	}
+	if opts.HostnameToIP != "" {
+		exit(max(status, lookupStatus(lookupHostnameToIP(os.Stdout, leases, opts.HostnameToIP))))
+	}
+
+	// Listings and reports go to --output, if given, in --output-encoding
+	out, err := openOutput(opts.Output, opts.OutputEncoding, opts.NoFlushPerRow)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	defer out.Close()
+
+	if opts.Reconcile != "" {
+		reservations, err := fetchReservations(opts.Reconcile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		report := reconcileLeases(leases, reservations, clock())
+		if err := printReconciliation(out, report, opts.Format == "json"); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	if opts.ARP {
+		entries, err := readARPTable(arpTablePath)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if err := printARPReport(out, compareARP(leases, entries, clock(), opts.Progress), opts.Format == "json"); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	if opts.Histogram {
+		if err := printHistogram(out, leases, opts.histogramBounds, clock()); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+	if opts.ExpiryHistogram {
+		if err := printExpiryHistogram(out, leases, opts.HistogramBucket); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	if opts.CheckConsistency {
+		anomalies, err := checkConsistency(out, leases, opts.Pools)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if anomalies > 0 {
+			out.Close()
+			exit(1)
+		}
+		return
+	}
+
+	if opts.ReportGaps {
+		if err := reportGaps(out, leases, opts.Pools); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	if opts.RemoteWrite != "" {
+		if err := pushRemoteWrite(opts.RemoteWrite, opts.RemoteWriteAuth, leaseMetrics(leases, clock(), opts.MetricPrefix, opts.metricLabels)); err != nil {
+			fatalf("%v", err)
+		}
+		slog.Info("pushed lease metrics", "url", opts.RemoteWrite)
+		return
+	}
+
+	// Push mode, e.g. from cron: report the lease state to a central server instead of printing it
+	if opts.PostURL != "" {
+		if leases == nil {
+			leases = []LeaseEntry{} // Post [] rather than null
+		}
+		if err := postWebhook(opts.PostURL, leases, opts.WebhookTimeout, opts.WebhookRetries); err != nil {
+			fatalf("posting the leases failed: %v", err)
+		}
+		slog.Info("posted leases", "url", opts.PostURL, "count", len(leases))
+		return
+	}
+
+	// If no leases were found, print a message and exit
+	if len(leases) == 0 && opts.Format == "table" {
+		fmt.Fprintln(out, "No lease entries found or file is empty.")
+		return
+	}
+
+	if err := render(out, leases, opts); err != nil {
+		fatalf("%v", err)
+	}
 }
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testOptions returns the parse options the command line defaults to
+func testOptions() options {
+	return options{TagsColumn: "no", UnknownTokens: "*"}
+}
+
+// leaseLine formats lease n the way dnsmasq writes it: expiring at the Unix time
+// expiry (0 for permanent), with MAC aa:00:00:xx:xx:xx and IP 10.x.x.x taken from n,
+// hostname host-n, no client ID and any extra trailing fields such as tags
+func leaseLine(expiry int64, n int, extra ...string) string {
+	fields := []string{
+		strconv.FormatInt(expiry, 10),
+		fmt.Sprintf("aa:00:00:%02x:%02x:%02x", n>>16&0xff, n>>8&0xff, n&0xff),
+		fmt.Sprintf("10.%d.%d.%d", n>>16&0xff, n>>8&0xff, n&0xff),
+		fmt.Sprintf("host-%d", n),
+		"*",
+	}
+	return strings.Join(append(fields, extra...), " ") + "\n"
+}
+
+// writeLeaseFile writes content to a lease file in a fresh temporary directory and returns its path
+func writeLeaseFile(t testing.TB, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// macsOf returns the MAC addresses of the leases, in order
+func macsOf(leases []LeaseEntry) []string {
+	macs := make([]string, len(leases))
+	for i, lease := range leases {
+		macs[i] = lease.MACAddress
+	}
+	return macs
+}
+
+func TestReadLeaseFileTagsColumn(t *testing.T) {
+	sixFields := leaseLine(0, 1, "red") + leaseLine(0, 2, "green,blue")
+	mixed := leaseLine(0, 1, "red") + leaseLine(0, 2)
+	tests := []struct {
+		name        string
+		tagsColumn  string
+		content     string
+		wantTags    []string
+		wantSkipped int
+	}{
+		{"no rejects a 6th field", "no", sixFields, []string{}, 2},
+		{"no reads plain dnsmasq files", "no", leaseLine(0, 1), []string{""}, 0},
+		{"auto detects tags on every line", "auto", sixFields, []string{"red", "green,blue"}, 0},
+		{"auto stays strict on mixed lines", "auto", mixed, []string{""}, 1},
+		{"yes requires the 6th field", "yes", mixed, []string{"red"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions()
+			opts.TagsColumn = tt.tagsColumn
+			leases, skipped, _, err := readLeaseFile(writeLeaseFile(t, "dnsmasq.leases", tt.content), opts)
+			if err != nil {
+				t.Fatalf("readLeaseFile: %v", err)
+			}
+			tags := []string{}
+			for _, lease := range leases {
+				tags = append(tags, lease.Tags)
+			}
+			if !slices.Equal(tags, tt.wantTags) {
+				t.Errorf("tags = %q, want %q", tags, tt.wantTags)
+			}
+			if len(skipped) != tt.wantSkipped {
+				t.Errorf("skipped %d lines, want %d", len(skipped), tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestAnonymizeMACsClientID(t *testing.T) {
+	salt := []byte("salt")
+	anonymized := anonymizeMAC("aa:bb:cc:dd:ee:ff", salt)
+	for _, tt := range []struct{ mac, clientID, want string }{
+		{"aa:bb:cc:dd:ee:ff", "01:AA:BB:CC:DD:EE:FF", "01:" + anonymized},
+		{"AA:BB:CC:DD:EE:FF", "ff:aa:bb:cc:dd:ee:ff:Host-A", "ff:" + anonymized + ":Host-A"},
+		{"aa:bb:cc:dd:ee:ff", "Office-PC", "Office-PC"},
+	} {
+		leases := []LeaseEntry{{MACAddress: tt.mac, ClientID: tt.clientID}}
+		anonymizeMACs(leases, salt, "lower")
+		if leases[0].MACAddress != anonymized {
+			t.Errorf("MAC %s anonymized to %s, want %s", tt.mac, leases[0].MACAddress, anonymized)
+		}
+		if leases[0].ClientID != tt.want {
+			t.Errorf("client ID %q anonymized to %q, want %q", tt.clientID, leases[0].ClientID, tt.want)
+		}
+	}
+}
+
+func TestResolvConfHostnameOrMAC(t *testing.T) {
+	leases := []LeaseEntry{
+		{Permanent: true, MACAddress: "aa:00:00:00:00:01", IPAddress: "10.0.0.1", Hostname: "DNS-1"},
+		{Permanent: true, MACAddress: "aa:00:00:00:00:02", IPAddress: "10.0.0.2", Hostname: "pihole"},
+		{Permanent: true, MACAddress: "aa:00:00:00:00:03", IPAddress: "10.0.0.3", Hostname: "laptop"},
+		{ExpiryTime: time.Unix(1, 0), MACAddress: "aa:00:00:00:00:04", IPAddress: "10.0.0.4", Hostname: "dns-2"},
+	}
+	opts := options{Format: "resolv-conf", Hostname: "dns-*", DNSServerMACs: "AA:00:00:00:00:02"}
+	var b strings.Builder
+	if err := printResolvConf(&b, filterLeases(leases, opts), opts); err != nil {
+		t.Fatalf("printResolvConf: %v", err)
+	}
+	// A server matching either the pattern or the MAC list is written; the expired one is not
+	if got, want := b.String(), "nameserver 10.0.0.1\nnameserver 10.0.0.2\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReadLeaseFilePartial(t *testing.T) {
+	complete := leaseLine(0, 1) + leaseLine(0, 2)
+	tests := []struct {
+		name        string
+		content     string
+		wantLeases  int
+		wantPartial bool
+	}{
+		{"complete", complete, 2, false},
+		{"empty", "", 0, false},
+		{"no trailing newline", strings.TrimSuffix(complete, "\n"), 2, true},
+		{"cut off mid-record", leaseLine(0, 1) + "0 aa:00:00:00:00:02 10.0\n", 1, true},
+		{"malformed line in the middle", "0 aa:00:00:00:00:01 10.0.0.1\n" + leaseLine(0, 2), 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leases, _, partial, err := readLeaseFile(writeLeaseFile(t, "dnsmasq.leases", tt.content), testOptions())
+			if err != nil {
+				t.Fatalf("readLeaseFile: %v", err)
+			}
+			if len(leases) != tt.wantLeases || partial != tt.wantPartial {
+				t.Errorf("got %d leases, partial %v; want %d, %v", len(leases), partial, tt.wantLeases, tt.wantPartial)
+			}
+		})
+	}
+}
+
+func TestParseLeaseFileRetryOnPartial(t *testing.T) {
+	complete := leaseLine(0, 1) + leaseLine(0, 2)
+	path := writeLeaseFile(t, "dnsmasq.leases", complete[:len(complete)-10])
+	// The writer finishes well within the retry delay
+	done := make(chan error)
+	go func() {
+		time.Sleep(partialRetryDelay / 4)
+		done <- os.WriteFile(path, []byte(complete), 0o644)
+	}()
+	opts := testOptions()
+	opts.RetryOnPartial = true
+	leases, err := parseLeaseFile(path, opts)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("parseLeaseFile: %v", err)
+	}
+	if len(leases) != 2 {
+		t.Errorf("got %d leases after the retry, want 2", len(leases))
+	}
+}
+
+func TestParseSimulatedTime(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"1700000000", time.Unix(1700000000, 0), false},
+		{"2024-10-15T09:00:00Z", time.Date(2024, 10, 15, 9, 0, 0, 0, time.UTC), false},
+		{"2024-10-15T11:00:00+02:00", time.Date(2024, 10, 15, 9, 0, 0, 0, time.UTC), false},
+		{"2024-10-15 09:00:00", time.Date(2024, 10, 15, 9, 0, 0, 0, time.Local), false},
+		{"yesterday", time.Time{}, true},
+		{"2024-10-15", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSimulatedTime(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSimulatedTime(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !got.Equal(tt.want) {
+			t.Errorf("parseSimulatedTime(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+// simulateNow sets clock to now for the rest of the test, as --simulate-now does
+func simulateNow(t *testing.T, now time.Time) {
+	t.Helper()
+	saved := clock
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = saved })
+}
+
+func TestSimulatedNowFilters(t *testing.T) {
+	now := time.Date(2024, 10, 15, 9, 0, 0, 0, time.UTC)
+	simulateNow(t, now)
+	leases := []LeaseEntry{
+		{ExpiryTime: now.Add(-time.Minute), MACAddress: "aa:00:00:00:00:01"}, // Expired a minute ago
+		{ExpiryTime: now.Add(30 * time.Minute), MACAddress: "aa:00:00:00:00:02"},
+		{ExpiryTime: now.Add(2 * time.Hour), MACAddress: "aa:00:00:00:00:03"}, // Exactly at the 2h bounds below
+		{ExpiryTime: now.Add(48 * time.Hour), MACAddress: "aa:00:00:00:00:04"},
+		{Permanent: true, MACAddress: "aa:00:00:00:00:05"},
+	}
+	tests := []struct {
+		name string
+		opts options
+		want []string
+	}{
+		{"active", options{Active: true}, []string{"aa:00:00:00:00:02", "aa:00:00:00:00:03", "aa:00:00:00:00:04", "aa:00:00:00:00:05"}},
+		{"expire-in", options{ExpireIn: 2 * time.Hour}, []string{"aa:00:00:00:00:02"}},
+		{"min-expiry", options{MinExpiry: time.Hour}, []string{"aa:00:00:00:00:03", "aa:00:00:00:00:04", "aa:00:00:00:00:05"}},
+		{"max-expiry", options{MaxExpiry: 24 * time.Hour}, []string{"aa:00:00:00:00:01", "aa:00:00:00:00:02", "aa:00:00:00:00:03"}},
+		{"min-expiry includes its bound", options{MinExpiry: 2 * time.Hour}, []string{"aa:00:00:00:00:03", "aa:00:00:00:00:04", "aa:00:00:00:00:05"}},
+		{"max-expiry excludes its bound", options{MaxExpiry: 2 * time.Hour}, []string{"aa:00:00:00:00:01", "aa:00:00:00:00:02"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := macsOf(filterLeases(leases, tt.opts)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	// --expire-in D keeps what --active --max-expiry D keeps, at the bound too
+	for _, d := range []time.Duration{30 * time.Minute, 2 * time.Hour, 48 * time.Hour} {
+		expireIn := macsOf(filterLeases(leases, options{ExpireIn: d}))
+		if maxExpiry := macsOf(filterLeases(leases, options{Active: true, MaxExpiry: d})); !slices.Equal(expireIn, maxExpiry) {
+			t.Errorf("--expire-in %v keeps %v, --active --max-expiry %v keeps %v", d, expireIn, d, maxExpiry)
+		}
+	}
+	for _, tt := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{"active"}, 4},
+		{[]string{"expired"}, 1},
+		{[]string{"total"}, 5},
+		{[]string{"expiring-soon", "1h"}, 1},
+	} {
+		if got := countLeases(leases, tt.args, clock()); got != tt.want {
+			t.Errorf("count %v = %d, want %d", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestSortLeases(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	leases := []LeaseEntry{
+		{ExpiryTime: base.Add(2 * time.Hour), MACAddress: "52:54:00:00:00:03", IPAddress: "10.0.0.10", Hostname: "beta", ClientID: "c"},
+		{Permanent: true, MACAddress: "AA:00:00:00:00:01", IPAddress: "10.0.0.9", Hostname: "Alpha", ClientID: "a"},
+		{ExpiryTime: base.Add(time.Hour), MACAddress: "b8:27:eb:00:00:02", IPAddress: "10.0.0.2", Hostname: "gamma", ClientID: "b"},
+	}
+	tests := []struct {
+		name       string
+		by         SortField
+		descending bool
+		want       []string
+	}{
+		{"expiry puts permanent last", SortByExpiry, false, []string{"b8:27:eb:00:00:02", "52:54:00:00:00:03", "AA:00:00:00:00:01"}},
+		{"expiry descending", SortByExpiry, true, []string{"AA:00:00:00:00:01", "52:54:00:00:00:03", "b8:27:eb:00:00:02"}},
+		{"mac ignores case", SortByMAC, false, []string{"52:54:00:00:00:03", "AA:00:00:00:00:01", "b8:27:eb:00:00:02"}},
+		{"ip numerically", SortByIP, false, []string{"b8:27:eb:00:00:02", "AA:00:00:00:00:01", "52:54:00:00:00:03"}},
+		{"hostname ignores case", SortByHostname, false, []string{"AA:00:00:00:00:01", "52:54:00:00:00:03", "b8:27:eb:00:00:02"}},
+		{"client id", SortByClientID, false, []string{"AA:00:00:00:00:01", "b8:27:eb:00:00:02", "52:54:00:00:00:03"}},
+		{"vendor puts unknown last", SortByVendor, false, []string{"52:54:00:00:00:03", "b8:27:eb:00:00:02", "AA:00:00:00:00:01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := slices.Clone(leases)
+			if got := macsOf(SortLeases(input, tt.by, tt.descending)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(macsOf(input), macsOf(leases)) {
+				t.Errorf("SortLeases reordered its input: %v", macsOf(input))
+			}
+		})
+	}
+}
+
+func TestSortLeasesStable(t *testing.T) {
+	leases := []LeaseEntry{
+		{MACAddress: "aa:00:00:00:00:01", Hostname: "same"},
+		{MACAddress: "aa:00:00:00:00:02", Hostname: "same"},
+		{MACAddress: "aa:00:00:00:00:03", Hostname: "same"},
+	}
+	for _, descending := range []bool{false, true} {
+		if got := macsOf(SortLeases(leases, SortByHostname, descending)); !slices.Equal(got, macsOf(leases)) {
+			t.Errorf("descending=%v: equal keys were reordered: %v", descending, got)
+		}
+	}
+}
+
+func TestSortLeasesNonASCIIHostnames(t *testing.T) {
+	// $LANG does not matter: hostnames compare by their lower-cased code points
+	t.Setenv("LANG", "sv_SE.UTF-8")
+	leases := []LeaseEntry{
+		{MACAddress: "aa:00:00:00:00:01", Hostname: "zeta"},
+		{MACAddress: "aa:00:00:00:00:02", Hostname: "Ärger"},
+		{MACAddress: "aa:00:00:00:00:03", Hostname: "東京-nas"},
+		{MACAddress: "aa:00:00:00:00:04", Hostname: "arger"},
+		{MACAddress: "aa:00:00:00:00:05", Hostname: "émile"},
+		{MACAddress: "aa:00:00:00:00:06", Hostname: "ärger"},
+	}
+	want := []string{"aa:00:00:00:00:04", "aa:00:00:00:00:01", "aa:00:00:00:00:02", "aa:00:00:00:00:06", "aa:00:00:00:00:05", "aa:00:00:00:00:03"}
+	if got := macsOf(SortLeases(leases, SortByHostname, false)); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSortLeasesInvalidField(t *testing.T) {
+	leases := []LeaseEntry{
+		{Permanent: true, MACAddress: "aa:00:00:00:00:01"},
+		{ExpiryTime: time.Unix(1700000000, 0), MACAddress: "aa:00:00:00:00:02"},
+	}
+	for _, by := range []SortField{-1, SortByVendor + 1, 100} {
+		if got := macsOf(SortLeases(leases, by, false)); !slices.Equal(got, []string{"aa:00:00:00:00:02", "aa:00:00:00:00:01"}) {
+			t.Errorf("SortLeases(%d) = %v, want the expiry order", by, got)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0s"},
+		{-time.Second, "EXPIRED"},
+		{-48 * time.Hour, "EXPIRED"},
+		{time.Second, "1s"},
+		{1500 * time.Millisecond, "2s"},
+		{59*time.Minute + 59*time.Second, "59m 59s"},
+		{time.Hour, "1h 0m 0s"},
+		{5*24*time.Hour + 3*time.Hour + 22*time.Minute + 10*time.Second, "5d 3h 22m 10s"},
+	}
+	for _, tt := range tests {
+		if got := FormatDuration(tt.d); got != tt.want {
+			t.Errorf("FormatDuration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestReadLeaseFileSkipsBlankAndCommentLines(t *testing.T) {
+	content := "\n# written by hand\n" + leaseLine(0, 1) + "   \n\t# indented comment\n\n" + leaseLine(0, 2) + "broken line\n\n"
+	leases, skipped, _, err := readLeaseFile(writeLeaseFile(t, "dnsmasq.leases", content), testOptions())
+	if err != nil {
+		t.Fatalf("readLeaseFile: %v", err)
+	}
+	if got, want := macsOf(leases), []string{"aa:00:00:00:00:01", "aa:00:00:00:00:02"}; !slices.Equal(got, want) {
+		t.Errorf("leases %v, want %v", got, want)
+	}
+	// Only the broken line is reported, with its line number in the file
+	if len(skipped) != 1 || skipped[0].Line != 8 {
+		t.Errorf("skipped %+v, want only line 8", skipped)
+	}
+}
+
+func TestReadLeaseFileTimestampUnit(t *testing.T) {
+	want := time.Unix(1700000000, 0)
+	seconds := leaseLine(1700000000, 1) + leaseLine(0, 2)
+	millis := leaseLine(1700000000000, 1) + leaseLine(0, 2)
+	tests := []struct {
+		name    string
+		unit    string
+		content string
+		want    time.Time
+	}{
+		{"seconds", "s", seconds, want},
+		{"milliseconds", "ms", millis, want},
+		{"seconds detected", "", seconds, want},
+		{"milliseconds detected", "", millis, want},
+		{"milliseconds read as seconds when told", "s", millis, time.Unix(1700000000000, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions()
+			opts.TimestampUnit = tt.unit
+			leases, skipped, _, err := readLeaseFile(writeLeaseFile(t, "dnsmasq.leases", tt.content), opts)
+			if err != nil || len(skipped) > 0 {
+				t.Fatalf("readLeaseFile: %v, skipped %v", err, skipped)
+			}
+			if len(leases) != 2 || !leases[0].ExpiryTime.Equal(tt.want) {
+				t.Fatalf("got %+v, want the first lease expiring at %v", leases, tt.want)
+			}
+			// A 0 timestamp means a permanent lease in either unit
+			if !leases[1].Permanent || !leases[1].ExpiryTime.IsZero() {
+				t.Errorf("second lease %+v, want permanent", leases[1])
+			}
+		})
+	}
+}
+
+// BenchmarkParseAndPrint parses a lease file of 100k synthetic leases and renders it as a table
+func BenchmarkParseAndPrint(b *testing.B) {
+	var data strings.Builder
+	for i := range 100000 {
+		data.WriteString(leaseLine(1700000000+int64(i), i))
+	}
+	path := writeLeaseFile(b, "dnsmasq.leases", data.String())
+	opts := testOptions()
+	opts.Format = "table"
+	opts.SeparatorLine = "-"
+	b.ReportAllocs()
+	for b.Loop() {
+		leases, err := parseLeaseFiles([]string{path}, opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(leases) != 100000 {
+			b.Fatalf("parsed %d leases, want 100000", len(leases))
+		}
+		if err := render(io.Discard, leases, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// bzip2Leases is leaseLine(1700000000, 1) + leaseLine(0, 2) compressed with bzip2 -9;
+// the standard library has no bzip2 writer
+const bzip2Leases = "\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\x5b\x76\x65\xbb\x00\x00\x1b\xd9\x80\x04" +
+	"\x10\x40\x13\x70\x90\x20\x40\x8c\x00\x20\x00\x48\x4a\x26\xd4\x68\x00\x25\x4d\x19" +
+	"\x06\x43\x2c\x89\xbb\xba\x51\x16\x3c\x26\x69\xa9\x80\x83\x3a\x18\xa0\x1a\x75\x72" +
+	"\x52\x53\xb2\x00\x43\x21\xe3\xe4\x94\x2e\xe4\x8a\x70\xa1\x20\xb6\xec\xcb\x76"
+
+func TestParseCompressedLeaseFile(t *testing.T) {
+	content := leaseLine(1700000000, 1) + leaseLine(0, 2)
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		wantErr string
+	}{
+		{"plain", "dnsmasq.leases", content, ""},
+		{"gzip", "dnsmasq.leases.1.gz", gzipped.String(), ""},
+		{"bzip2", "dnsmasq.leases.1.bz2", bzip2Leases, ""},
+		{"unknown extension read as text", "dnsmasq.leases.old", content, ""},
+		{"corrupt gzip", "dnsmasq.leases.2.gz", content, "gzip"},
+		{"corrupt bzip2", "dnsmasq.leases.2.bz2", content, "bzip2"},
+		{"xz rejected", "dnsmasq.leases.3.xz", content, "unsupported compression .xz"},
+		{"zstd rejected", "dnsmasq.leases.3.ZST", content, "unsupported compression .zst"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leases, err := parseLeaseFile(writeLeaseFile(t, tt.file, tt.data), testOptions())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseLeaseFile = %d leases, %v; want an error mentioning %q", len(leases), err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseLeaseFile: %v", err)
+			}
+			if got, want := macsOf(leases), []string{"aa:00:00:00:00:01", "aa:00:00:00:00:02"}; !slices.Equal(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"laptop", 6},
+		{"東京", 4},
+		{"ｶﾀｶﾅ", 4},       // Halfwidth katakana
+		{"ＡＢ", 4},         // Fullwidth forms
+		{"café", 4},       // Precomposed é
+		{"cafe\u0301", 4}, // e + combining acute accent
+		{"🚀", 2},          // Transport and map symbols
+		{"☔", 2},          // Miscellaneous symbols, wide
+		{"☀", 1},          // Miscellaneous symbols, narrow
+		{"한국", 4},
+	}
+	for _, tt := range tests {
+		if got := displayWidth(tt.s); got != tt.want {
+			t.Errorf("displayWidth(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestPrintTableMultibyteHostnames(t *testing.T) {
+	leases := []LeaseEntry{
+		{Permanent: true, MACAddress: "aa:00:00:00:00:01", IPAddress: "10.0.0.1", Hostname: "東京-nas", ClientID: "*"},
+		{Permanent: true, MACAddress: "aa:00:00:00:00:02", IPAddress: "10.0.0.2", Hostname: "🚀rocket", ClientID: "*"},
+		{Permanent: true, MACAddress: "aa:00:00:00:00:03", IPAddress: "10.0.0.3", Hostname: "plain", ClientID: "*"},
+	}
+	for _, separator := range []string{"-", "＝"} {
+		var b strings.Builder
+		if err := printTable(&b, leases, options{SeparatorLine: separator}); err != nil {
+			t.Fatalf("printTable: %v", err)
+		}
+		lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+		if len(lines) != 5 {
+			t.Fatalf("separator %q: got %d lines, want 5:\n%s", separator, len(lines), b.String())
+		}
+		// The Client ID column, the last one, starts at the same display column on every row
+		header := lines[0][:strings.Index(lines[0], "Client ID")]
+		for _, line := range lines[2:] {
+			if got := displayWidth(line[:strings.LastIndex(line, "*")]); got != displayWidth(header) {
+				t.Errorf("separator %q: row %q has its last column at %d, want %d", separator, line, got, displayWidth(header))
+			}
+		}
+		// The underline is no wider than the header it underlines
+		if got, want := displayWidth(strings.Fields(lines[1])[0]), displayWidth("Expiry Time"); got > want || got < want-1 {
+			t.Errorf("separator %q: underline of Expiry Time is %d columns wide, want %d", separator, got, want)
+		}
+	}
+}
+
+func TestPrintTableASCIIHostnames(t *testing.T) {
+	leases := []LeaseEntry{
+		{Permanent: true, MACAddress: "aa:00:00:00:00:01", IPAddress: "10.0.0.1", Hostname: "nas", ClientID: "*"},
+		{Permanent: true, MACAddress: "aa:00:00:00:00:02", IPAddress: "fd00::2", Hostname: "printer-upstairs", ClientID: "01:aa:00:00:00:00:02"},
+	}
+	var b strings.Builder
+	if err := printTable(&b, leases, options{SeparatorLine: "-"}); err != nil {
+		t.Fatalf("printTable: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4:\n%s", len(lines), b.String())
+	}
+	// Without wide characters tabwriter lays out the table, each column starting at the same offset
+	for header, values := range map[string][]string{
+		"IP Address": {"10.0.0.1", "fd00::2"},
+		"Client ID":  {"*", "01:aa:00:00:00:00:02"},
+	} {
+		want := strings.Index(lines[0], header)
+		for i, value := range values {
+			if got := strings.Index(lines[i+2], value); got != want {
+				t.Errorf("%s %q starts at %d, want %d:\n%s", header, value, got, want, b.String())
+			}
+		}
+	}
+}
+
+func TestLeasesByMACAndHostname(t *testing.T) {
+	leases := []LeaseEntry{
+		{MACAddress: "AA:00:00:00:00:01", IPAddress: "10.0.0.1", Hostname: "Laptop"},
+		{MACAddress: "aa:00:00:00:00:02", IPAddress: "10.0.0.2", Hostname: "*"},
+		{MACAddress: "aa-00-00-00-00-01", IPAddress: "fd00::1", Hostname: "laptop"},
+		{MACAddress: "aa:00:00:00:00:03", IPAddress: "10.0.0.3", Hostname: "LAPTOP"},
+		{MACAddress: "aa:00:00:00:00:01", IPAddress: "10.0.0.9", Hostname: "phone"},
+	}
+	ipsOf := func(group []LeaseEntry) []string {
+		ips := make([]string, len(group))
+		for i, lease := range group {
+			ips[i] = lease.IPAddress
+		}
+		return ips
+	}
+	tests := []struct {
+		name   string
+		groups map[string][]LeaseEntry
+		want   map[string][]string
+	}{
+		{"by MAC", LeasesByMAC(leases), map[string][]string{
+			"aa:00:00:00:00:01": {"10.0.0.1", "fd00::1", "10.0.0.9"},
+			"aa:00:00:00:00:02": {"10.0.0.2"},
+			"aa:00:00:00:00:03": {"10.0.0.3"},
+		}},
+		{"by hostname", LeasesByHostname(leases), map[string][]string{
+			"laptop": {"10.0.0.1", "fd00::1", "10.0.0.3"},
+			"phone":  {"10.0.0.9"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.groups) != len(tt.want) {
+				t.Fatalf("got %d groups, want %d", len(tt.groups), len(tt.want))
+			}
+			for key, want := range tt.want {
+				if got := ipsOf(tt.groups[key]); !slices.Equal(got, want) {
+					t.Errorf("group %q = %v, want %v (input order)", key, got, want)
+				}
+			}
+		})
+	}
+	// Map iteration order is random; the order within a group must not be
+	for range 20 {
+		again := LeasesByMAC(leases)
+		if got := ipsOf(again["aa:00:00:00:00:01"]); !slices.Equal(got, []string{"10.0.0.1", "fd00::1", "10.0.0.9"}) {
+			t.Fatalf("LeasesByMAC order changed between calls: %v", got)
+		}
+	}
+}